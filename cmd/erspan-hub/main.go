@@ -10,8 +10,10 @@ import (
 	"syscall"
 	"time"
 
+	"anthonyuk.dev/erspan-hub/internal"
 	"anthonyuk.dev/erspan-hub/internal/capture"
 	"anthonyuk.dev/erspan-hub/internal/config"
+	"anthonyuk.dev/erspan-hub/internal/forward"
 	"anthonyuk.dev/erspan-hub/internal/grpc"
 	"anthonyuk.dev/erspan-hub/internal/rest"
 
@@ -68,13 +70,89 @@ func main() {
 		logger = slog.New(slog.NewTextHandler(os.Stdout, &logHandlerOptions))
 	}
 
-	server(cfg, logger)
+	if err := server(cfg, logger); err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// buildConfig parses the capture and forwarding options of the configuration
+func buildConfig(cfg *config.Config) (*capture.Config, error) {
+	dropPolicy, err := forward.ParseDropPolicy(cfg.DropPolicy)
+	if err != nil {
+		return nil, err
+	}
+	filterSources, err := internal.ParsePrefixes(cfg.FilterSources)
+	if err != nil {
+		return nil, err
+	}
+	filterGREProtocols, err := capture.ParseGREProtocols(cfg.FilterGREProtocols)
+	if err != nil {
+		return nil, err
+	}
+	filterSessionIDs, err := internal.ParseIDRanges(cfg.FilterSessionIDs)
+	if err != nil {
+		return nil, err
+	}
+	allowSources, err := internal.ParsePrefixes(cfg.AllowSources)
+	if err != nil {
+		return nil, err
+	}
+	allowSessionIDs, err := internal.ParseIDRanges(cfg.AllowSessionIDs)
+	if err != nil {
+		return nil, err
+	}
+	virtualStreams, err := forward.ParseVirtualStreams(cfg.VirtualStreams)
+	if err != nil {
+		return nil, err
+	}
+	return &capture.Config{
+		Backend:         capture.Backend(cfg.CaptureBackend),
+		Interface:       cfg.CaptureIface,
+		RingBlockSize:   cfg.RingBlockSize,
+		RingBlocks:      cfg.RingBlocks,
+		Workers:         cfg.CaptureWorkers,
+		IPv6:            cfg.CaptureIPv6,
+		TypeISessionID:  cfg.TypeISessionID,
+		TimestampSource: internal.TimestampSource(cfg.TimestampSource),
+		VXLANPort:       cfg.VXLANPort,
+		TZSPPort:        cfg.TZSPPort,
+		ReplayFile:      cfg.ReplayFile,
+		ReplaySpeed:     cfg.ReplaySpeed,
+		ReplayLoop:      cfg.ReplayLoop,
+		Forward: forward.Config{
+			QueueLength:       cfg.QueueLength,
+			DropPolicy:        dropPolicy,
+			BlockTimeout:      time.Duration(cfg.BlockTimeout) * time.Millisecond,
+			AllowedSources:    allowSources,
+			AllowedSessionIDs: allowSessionIDs,
+			MaxStreams:        cfg.MaxStreams,
+			IdleTimeout:       time.Duration(cfg.IdleTimeout) * time.Second,
+			ExpiryTimeout:     time.Duration(cfg.ExpiryTimeout) * time.Second,
+			PendingTimeout:    time.Duration(cfg.PendingTimeout) * time.Second,
+			InventoryFile:     cfg.InventoryFile,
+			VirtualStreams:    virtualStreams,
+			MergeJitter:       time.Duration(cfg.MergeJitter) * time.Millisecond,
+		},
+		FilterSources:      filterSources,
+		FilterGREProtocols: filterGREProtocols,
+		FilterSessionIDs:   filterSessionIDs,
+	}, nil
 }
 
-func server(cfg *config.Config, logger *slog.Logger) {
-	ci := capture.NewCaptureInstance(logger)
+// server runs the hub until it is stopped by a signal. It returns an error if the
+// configuration is invalid.
+func server(cfg *config.Config, logger *slog.Logger) error {
+	captureCfg, err := buildConfig(cfg)
+	if err != nil {
+		return err
+	}
+	ci := capture.NewCaptureInstance(captureCfg, logger)
+	if err := ci.ForwardSessionManager().ReloadInventory(); err != nil {
+		return err
+	}
 	go func() {
-		rest.RunServer(&rest.Config{BindIP: cfg.RestIP, Port: cfg.RestPort, RestPrefix: cfg.RestPrefix}, ci.ForwardSessionManager())
+		rest.RunServer(&rest.Config{BindIP: cfg.RestIP, Port: cfg.RestPort, RestPrefix: cfg.RestPrefix}, ci.ForwardSessionManager(), ci)
 	}()
 	go func() {
 		err := grpc.RunServer(&grpc.Config{BindIP: cfg.GrpcIP, Port: cfg.GrpcPort, TLSCertFile: cfg.GrpcTLSCertFile, TLSKeyFile: cfg.GrpcTLSKeyFile}, ci.ForwardSessionManager())
@@ -95,10 +173,21 @@ func server(cfg *config.Config, logger *slog.Logger) {
 		}
 	}()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := ci.ForwardSessionManager().ReloadInventory(); err != nil {
+				logger.Error("failed to reload inventory", "error", err)
+			}
+		}
+	}()
+
 	<-quit
 	logger.Info("🛑 Stopping capture instance...")
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 	ci.Shutdown()
 	<-ctx.Done()
+	return nil
 }
@@ -22,13 +22,18 @@ const (
 )
 
 // The client sends this message to start the packet stream
+// The stream is given either by stream_info_id, by src_ip, erspan_id and encap, or by streams.
 type ForwardRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	SrcIp         string                 `protobuf:"bytes,1,opt,name=src_ip,json=srcIp,proto3" json:"src_ip,omitempty"`                                                                                           // Source IP address
-	ErspanId      uint32                 `protobuf:"varint,2,opt,name=erspan_id,json=erspanId,proto3" json:"erspan_id,omitempty"`                                                                                 // ERSPAN ID
-	StreamInfoId  string                 `protobuf:"bytes,3,opt,name=stream_info_id,json=streamInfoId,proto3" json:"stream_info_id,omitempty"`                                                                    // Stream information ID
-	Filter        string                 `protobuf:"bytes,4,opt,name=filter,proto3" json:"filter,omitempty"`                                                                                                      // Filter for the stream
-	ClientInfo    map[string]string      `protobuf:"bytes,15,rep,name=client_info,json=clientInfo,proto3" json:"client_info,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Arbitrary key/value pairs with info about the client, e.g. OS, version, user, etc.
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	SrcIp        string                 `protobuf:"bytes,1,opt,name=src_ip,json=srcIp,proto3" json:"src_ip,omitempty"`                        // Exporter IP address, the stream does not need to exist yet
+	ErspanId     uint32                 `protobuf:"varint,2,opt,name=erspan_id,json=erspanId,proto3" json:"erspan_id,omitempty"`              // ERSPAN ID, VXLAN VNI, TZSP sensor ID or GRE key depending on encap
+	StreamInfoId string                 `protobuf:"bytes,3,opt,name=stream_info_id,json=streamInfoId,proto3" json:"stream_info_id,omitempty"` // Stream information ID, or the key of a captured stream such as 10.1.2.3/42
+	Filter       string                 `protobuf:"bytes,4,opt,name=filter,proto3" json:"filter,omitempty"`                                   // Filter for the stream
+	Encap        string                 `protobuf:"bytes,5,opt,name=encap,proto3" json:"encap,omitempty"`                                     // Encapsulation of the stream given by src_ip: erspan (default), vxlan, tzsp or teb
+	// Several streams in one capture, each a stream ID, a stream key, all sessions of an
+	// exporter (10.1.2.3/*) or all captured streams (*). Each stream gets its own pcapng interface.
+	Streams       []string          `protobuf:"bytes,6,rep,name=streams,proto3" json:"streams,omitempty"`
+	ClientInfo    map[string]string `protobuf:"bytes,15,rep,name=client_info,json=clientInfo,proto3" json:"client_info,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Arbitrary key/value pairs with info about the client, e.g. OS, version, user, etc.
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -91,6 +96,20 @@ func (x *ForwardRequest) GetFilter() string {
 	return ""
 }
 
+func (x *ForwardRequest) GetEncap() string {
+	if x != nil {
+		return x.Encap
+	}
+	return ""
+}
+
+func (x *ForwardRequest) GetStreams() []string {
+	if x != nil {
+		return x.Streams
+	}
+	return nil
+}
+
 func (x *ForwardRequest) GetClientInfo() map[string]string {
 	if x != nil {
 		return x.ClientInfo
@@ -335,17 +354,19 @@ var File_pcap_v1_pcap_proto protoreflect.FileDescriptor
 
 const file_pcap_v1_pcap_proto_rawDesc = "" +
 	"\n" +
-	"\x12pcap/v1/pcap.proto\x12\x12erspan_hub.pcap.v1\"\x9c\x02\n" +
+	"\x12pcap/v1/pcap.proto\x12\x12erspan_hub.pcap.v1\"\xcc\x02\n" +
 	"\x0eForwardRequest\x12\x15\n" +
 	"\x06src_ip\x18\x01 \x01(\tR\x05srcIp\x12\x1b\n" +
 	"\terspan_id\x18\x02 \x01(\rR\berspanId\x12$\n" +
 	"\x0estream_info_id\x18\x03 \x01(\tR\fstreamInfoId\x12\x16\n" +
-	"\x06filter\x18\x04 \x01(\tR\x06filter\x12S\n" +
+	"\x06filter\x18\x04 \x01(\tR\x06filter\x12\x14\n" +
+	"\x05encap\x18\x05 \x01(\tR\x05encap\x12\x18\n" +
+	"\astreams\x18\x06 \x03(\tR\astreams\x12S\n" +
 	"\vclient_info\x18\x0f \x03(\v22.erspan_hub.pcap.v1.ForwardRequest.ClientInfoEntryR\n" +
 	"clientInfo\x1a=\n" +
 	"\x0fClientInfoEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01J\x04\b\x05\x10\x0f\"i\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01J\x04\b\a\x10\x0f\"i\n" +
 	"\vPacketBlock\x12\x1c\n" +
 	"\ttimestamp\x18\x01 \x01(\x03R\ttimestamp\x12!\n" +
 	"\fpacket_count\x18\x02 \x01(\rR\vpacketCount\x12\x19\n" +
@@ -22,15 +22,18 @@ const (
 )
 
 type ForwardSession struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	SrcIp         uint32                 `protobuf:"fixed32,1,opt,name=src_ip,json=srcIp,proto3" json:"src_ip,omitempty"`
-	ErspanId      uint32                 `protobuf:"varint,2,opt,name=erspan_id,json=erspanId,proto3" json:"erspan_id,omitempty"`
-	StreamInfoId  string                 `protobuf:"bytes,3,opt,name=stream_info_id,json=streamInfoId,proto3" json:"stream_info_id,omitempty"`
-	Type          string                 `protobuf:"bytes,4,opt,name=type,proto3" json:"type,omitempty"`
-	Filter        string                 `protobuf:"bytes,5,opt,name=filter,proto3" json:"filter,omitempty"`
-	Info          map[string]string      `protobuf:"bytes,16,rep,name=info,proto3" json:"info,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	SrcIp          uint32                 `protobuf:"fixed32,1,opt,name=src_ip,json=srcIp,proto3" json:"src_ip,omitempty"` // IPv4 source only, 0 for IPv6 (use src_addr)
+	ErspanId       uint32                 `protobuf:"varint,2,opt,name=erspan_id,json=erspanId,proto3" json:"erspan_id,omitempty"`
+	StreamInfoId   string                 `protobuf:"bytes,3,opt,name=stream_info_id,json=streamInfoId,proto3" json:"stream_info_id,omitempty"`
+	Type           string                 `protobuf:"bytes,4,opt,name=type,proto3" json:"type,omitempty"`
+	Filter         string                 `protobuf:"bytes,5,opt,name=filter,proto3" json:"filter,omitempty"`
+	SrcAddr        []byte                 `protobuf:"bytes,7,opt,name=src_addr,json=srcAddr,proto3" json:"src_addr,omitempty"`                       // Source IP address, 4 bytes for IPv4 or 16 bytes for IPv6
+	Encap          string                 `protobuf:"bytes,8,opt,name=encap,proto3" json:"encap,omitempty"`                                          // Encapsulation of the stream: erspan, vxlan, tzsp or teb
+	DroppedPackets uint64                 `protobuf:"varint,9,opt,name=dropped_packets,json=droppedPackets,proto3" json:"dropped_packets,omitempty"` // Packets dropped because the session's queue was full
+	Info           map[string]string      `protobuf:"bytes,16,rep,name=info,proto3" json:"info,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
 }
 
 func (x *ForwardSession) Reset() {
@@ -98,6 +101,27 @@ func (x *ForwardSession) GetFilter() string {
 	return ""
 }
 
+func (x *ForwardSession) GetSrcAddr() []byte {
+	if x != nil {
+		return x.SrcAddr
+	}
+	return nil
+}
+
+func (x *ForwardSession) GetEncap() string {
+	if x != nil {
+		return x.Encap
+	}
+	return ""
+}
+
+func (x *ForwardSession) GetDroppedPackets() uint64 {
+	if x != nil {
+		return x.DroppedPackets
+	}
+	return 0
+}
+
 func (x *ForwardSession) GetInfo() map[string]string {
 	if x != nil {
 		return x.Info
@@ -108,14 +132,24 @@ func (x *ForwardSession) GetInfo() map[string]string {
 type StreamInfo struct {
 	state           protoimpl.MessageState `protogen:"open.v1"`
 	Id              string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	SrcIp           uint32                 `protobuf:"fixed32,2,opt,name=src_ip,json=srcIp,proto3" json:"src_ip,omitempty"`
-	ErspanId        uint32                 `protobuf:"varint,3,opt,name=erspan_id,json=erspanId,proto3" json:"erspan_id,omitempty"`
+	SrcIp           uint32                 `protobuf:"fixed32,2,opt,name=src_ip,json=srcIp,proto3" json:"src_ip,omitempty"`         // IPv4 source only, 0 for IPv6 (use src_addr)
+	ErspanId        uint32                 `protobuf:"varint,3,opt,name=erspan_id,json=erspanId,proto3" json:"erspan_id,omitempty"` // ERSPAN ID, VXLAN VNI, TZSP sensor ID or GRE key depending on encap
 	ErspanVersion   uint32                 `protobuf:"varint,4,opt,name=erspan_version,json=erspanVersion,proto3" json:"erspan_version,omitempty"`
 	FirstSeen       int64                  `protobuf:"varint,5,opt,name=first_seen,json=firstSeen,proto3" json:"first_seen,omitempty"` // Unix timestamp
 	LastSeen        int64                  `protobuf:"varint,6,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`    // Unix timestamp
 	Packets         uint64                 `protobuf:"varint,7,opt,name=packets,proto3" json:"packets,omitempty"`
 	Bytes           uint64                 `protobuf:"varint,8,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	SrcAddr         []byte                 `protobuf:"bytes,9,opt,name=src_addr,json=srcAddr,proto3" json:"src_addr,omitempty"`                         // Source IP address, 4 bytes for IPv4 or 16 bytes for IPv6
+	SeqLost         uint64                 `protobuf:"varint,10,opt,name=seq_lost,json=seqLost,proto3" json:"seq_lost,omitempty"`                       // GRE sequence numbers never received
+	SeqDuplicate    uint64                 `protobuf:"varint,11,opt,name=seq_duplicate,json=seqDuplicate,proto3" json:"seq_duplicate,omitempty"`        // GRE sequence numbers received more than once
+	SeqOutOfOrder   uint64                 `protobuf:"varint,12,opt,name=seq_out_of_order,json=seqOutOfOrder,proto3" json:"seq_out_of_order,omitempty"` // GRE sequence numbers received after a later one
+	Encap           string                 `protobuf:"bytes,13,opt,name=encap,proto3" json:"encap,omitempty"`                                           // Encapsulation of the stream: erspan, vxlan, tzsp, teb or virtual (merged by the hub)
+	State           string                 `protobuf:"bytes,14,opt,name=state,proto3" json:"state,omitempty"`                                           // Lifecycle state: active, idle or expired
 	ForwardSessions []*ForwardSession      `protobuf:"bytes,16,rep,name=forward_sessions,json=forwardSessions,proto3" json:"forward_sessions,omitempty"`
+	Name            string                 `protobuf:"bytes,17,opt,name=name,proto3" json:"name,omitempty"` // From the inventory, empty if the stream is not in it, or the virtual stream name
+	Site            string                 `protobuf:"bytes,18,opt,name=site,proto3" json:"site,omitempty"`
+	Description     string                 `protobuf:"bytes,19,opt,name=description,proto3" json:"description,omitempty"`
+	Labels          map[string]string      `protobuf:"bytes,20,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	unknownFields   protoimpl.UnknownFields
 	sizeCache       protoimpl.SizeCache
 }
@@ -206,6 +240,48 @@ func (x *StreamInfo) GetBytes() uint64 {
 	return 0
 }
 
+func (x *StreamInfo) GetSrcAddr() []byte {
+	if x != nil {
+		return x.SrcAddr
+	}
+	return nil
+}
+
+func (x *StreamInfo) GetSeqLost() uint64 {
+	if x != nil {
+		return x.SeqLost
+	}
+	return 0
+}
+
+func (x *StreamInfo) GetSeqDuplicate() uint64 {
+	if x != nil {
+		return x.SeqDuplicate
+	}
+	return 0
+}
+
+func (x *StreamInfo) GetSeqOutOfOrder() uint64 {
+	if x != nil {
+		return x.SeqOutOfOrder
+	}
+	return 0
+}
+
+func (x *StreamInfo) GetEncap() string {
+	if x != nil {
+		return x.Encap
+	}
+	return ""
+}
+
+func (x *StreamInfo) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
 func (x *StreamInfo) GetForwardSessions() []*ForwardSession {
 	if x != nil {
 		return x.ForwardSessions
@@ -213,8 +289,37 @@ func (x *StreamInfo) GetForwardSessions() []*ForwardSession {
 	return nil
 }
 
+func (x *StreamInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *StreamInfo) GetSite() string {
+	if x != nil {
+		return x.Site
+	}
+	return ""
+}
+
+func (x *StreamInfo) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *StreamInfo) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
 type ListStreamsRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
+	Query         string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"` // Only list streams matching all words, "key=value" matches a label
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -249,6 +354,13 @@ func (*ListStreamsRequest) Descriptor() ([]byte, []int) {
 	return file_streams_v1_list_proto_rawDescGZIP(), []int{2}
 }
 
+func (x *ListStreamsRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
 type ListStreamsResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Streams       []*StreamInfo          `protobuf:"bytes,1,rep,name=streams,proto3" json:"streams,omitempty"`
@@ -297,17 +409,21 @@ var File_streams_v1_list_proto protoreflect.FileDescriptor
 
 const file_streams_v1_list_proto_rawDesc = "" +
 	"\n" +
-	"\x15streams/v1/list.proto\x12\x15erspan_hub.streams.v1\"\x9a\x02\n" +
+	"\x15streams/v1/list.proto\x12\x15erspan_hub.streams.v1\"\xf4\x02\n" +
 	"\x0eForwardSession\x12\x15\n" +
 	"\x06src_ip\x18\x01 \x01(\aR\x05srcIp\x12\x1b\n" +
 	"\terspan_id\x18\x02 \x01(\rR\berspanId\x12$\n" +
 	"\x0estream_info_id\x18\x03 \x01(\tR\fstreamInfoId\x12\x12\n" +
 	"\x04type\x18\x04 \x01(\tR\x04type\x12\x16\n" +
-	"\x06filter\x18\x05 \x01(\tR\x06filter\x12C\n" +
+	"\x06filter\x18\x05 \x01(\tR\x06filter\x12\x19\n" +
+	"\bsrc_addr\x18\a \x01(\fR\asrcAddr\x12\x14\n" +
+	"\x05encap\x18\b \x01(\tR\x05encap\x12'\n" +
+	"\x0fdropped_packets\x18\t \x01(\x04R\x0edroppedPackets\x12C\n" +
 	"\x04info\x18\x10 \x03(\v2/.erspan_hub.streams.v1.ForwardSession.InfoEntryR\x04info\x1a7\n" +
 	"\tInfoEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01J\x04\b\a\x10\x10\"\xbb\x02\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01J\x04\b\n" +
+	"\x10\x10\"\xb7\x05\n" +
 	"\n" +
 	"StreamInfo\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x15\n" +
@@ -318,9 +434,24 @@ const file_streams_v1_list_proto_rawDesc = "" +
 	"first_seen\x18\x05 \x01(\x03R\tfirstSeen\x12\x1b\n" +
 	"\tlast_seen\x18\x06 \x01(\x03R\blastSeen\x12\x18\n" +
 	"\apackets\x18\a \x01(\x04R\apackets\x12\x14\n" +
-	"\x05bytes\x18\b \x01(\x04R\x05bytes\x12P\n" +
-	"\x10forward_sessions\x18\x10 \x03(\v2%.erspan_hub.streams.v1.ForwardSessionR\x0fforwardSessionsJ\x04\b\t\x10\x10\"\x14\n" +
-	"\x12ListStreamsRequest\"R\n" +
+	"\x05bytes\x18\b \x01(\x04R\x05bytes\x12\x19\n" +
+	"\bsrc_addr\x18\t \x01(\fR\asrcAddr\x12\x19\n" +
+	"\bseq_lost\x18\n" +
+	" \x01(\x04R\aseqLost\x12#\n" +
+	"\rseq_duplicate\x18\v \x01(\x04R\fseqDuplicate\x12'\n" +
+	"\x10seq_out_of_order\x18\f \x01(\x04R\rseqOutOfOrder\x12\x14\n" +
+	"\x05encap\x18\r \x01(\tR\x05encap\x12\x14\n" +
+	"\x05state\x18\x0e \x01(\tR\x05state\x12P\n" +
+	"\x10forward_sessions\x18\x10 \x03(\v2%.erspan_hub.streams.v1.ForwardSessionR\x0fforwardSessions\x12\x12\n" +
+	"\x04name\x18\x11 \x01(\tR\x04name\x12\x12\n" +
+	"\x04site\x18\x12 \x01(\tR\x04site\x12 \n" +
+	"\vdescription\x18\x13 \x01(\tR\vdescription\x12E\n" +
+	"\x06labels\x18\x14 \x03(\v2-.erspan_hub.streams.v1.StreamInfo.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01J\x04\b\x0f\x10\x10\"*\n" +
+	"\x12ListStreamsRequest\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\"R\n" +
 	"\x13ListStreamsResponse\x12;\n" +
 	"\astreams\x18\x01 \x03(\v2!.erspan_hub.streams.v1.StreamInfoR\astreams2v\n" +
 	"\x0eStreamsService\x12d\n" +
@@ -338,25 +469,27 @@ func file_streams_v1_list_proto_rawDescGZIP() []byte {
 	return file_streams_v1_list_proto_rawDescData
 }
 
-var file_streams_v1_list_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_streams_v1_list_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_streams_v1_list_proto_goTypes = []any{
 	(*ForwardSession)(nil),      // 0: erspan_hub.streams.v1.ForwardSession
 	(*StreamInfo)(nil),          // 1: erspan_hub.streams.v1.StreamInfo
 	(*ListStreamsRequest)(nil),  // 2: erspan_hub.streams.v1.ListStreamsRequest
 	(*ListStreamsResponse)(nil), // 3: erspan_hub.streams.v1.ListStreamsResponse
 	nil,                         // 4: erspan_hub.streams.v1.ForwardSession.InfoEntry
+	nil,                         // 5: erspan_hub.streams.v1.StreamInfo.LabelsEntry
 }
 var file_streams_v1_list_proto_depIdxs = []int32{
 	4, // 0: erspan_hub.streams.v1.ForwardSession.info:type_name -> erspan_hub.streams.v1.ForwardSession.InfoEntry
 	0, // 1: erspan_hub.streams.v1.StreamInfo.forward_sessions:type_name -> erspan_hub.streams.v1.ForwardSession
-	1, // 2: erspan_hub.streams.v1.ListStreamsResponse.streams:type_name -> erspan_hub.streams.v1.StreamInfo
-	2, // 3: erspan_hub.streams.v1.StreamsService.ListStreams:input_type -> erspan_hub.streams.v1.ListStreamsRequest
-	3, // 4: erspan_hub.streams.v1.StreamsService.ListStreams:output_type -> erspan_hub.streams.v1.ListStreamsResponse
-	4, // [4:5] is the sub-list for method output_type
-	3, // [3:4] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	5, // 2: erspan_hub.streams.v1.StreamInfo.labels:type_name -> erspan_hub.streams.v1.StreamInfo.LabelsEntry
+	1, // 3: erspan_hub.streams.v1.ListStreamsResponse.streams:type_name -> erspan_hub.streams.v1.StreamInfo
+	2, // 4: erspan_hub.streams.v1.StreamsService.ListStreams:input_type -> erspan_hub.streams.v1.ListStreamsRequest
+	3, // 5: erspan_hub.streams.v1.StreamsService.ListStreams:output_type -> erspan_hub.streams.v1.ListStreamsResponse
+	5, // [5:6] is the sub-list for method output_type
+	4, // [4:5] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_streams_v1_list_proto_init() }
@@ -370,7 +503,7 @@ func file_streams_v1_list_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_streams_v1_list_proto_rawDesc), len(file_streams_v1_list_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   5,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
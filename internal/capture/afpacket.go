@@ -0,0 +1,226 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"anthonyuk.dev/erspan-hub/internal"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// TPACKET_V3 frames are variable length, the frame size only has to divide the block size
+	packetRingFrameSize = 2048
+	// a block is handed to the hub after this many milliseconds even if it is not full
+	packetRingRetireTimeout = 10
+	// how often a blocked next checks whether the ring has been closed, in milliseconds
+	packetRingPollTimeout = 100
+	// offset of struct tpacket_hdr_v1 in struct tpacket_block_desc
+	packetRingBlockHeaderOffset = 8
+)
+
+// packetRing is the AF_PACKET backend. The kernel fills a TPACKET_V3 ring of
+// blocks shared with the hub, so a single poll hands over many packets, and a
+// socket filter drops everything but GRE before it reaches the ring.
+type packetRing struct {
+	name      string
+	fd        int
+	ring      []byte
+	blockSize int
+	blocks    int
+	hubClock  bool
+
+	mu       sync.Mutex
+	closed   atomic.Bool
+	block    int                // index of the block being read
+	blockHdr *unix.TpacketHdrV1 // header of the block being read, nil if waiting for the kernel
+	pktsLeft uint32             // packets left to read in the block
+	offset   int                // offset of the next packet in the block
+	buf      []byte
+	drops    prometheus.Counter
+	pkt      rawPacket
+}
+
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
+
+func (ci *CaptureInstance) openPacketRing() (*packetRing, error) {
+	blockSize, blocks := int(ci.config.RingBlockSize), int(ci.config.RingBlocks)
+	if blockSize <= 0 || blockSize%os.Getpagesize() != 0 {
+		return nil, fmt.Errorf("ring block size %d is not a multiple of the page size", blockSize)
+	}
+	if blocks <= 0 {
+		return nil, fmt.Errorf("ring needs at least one block")
+	}
+	switch ci.config.TimestampSource {
+	case "", internal.TimestampSourceHub, internal.TimestampSourceKernel, internal.TimestampSourceHardware, internal.TimestampSourceErspan:
+	default:
+		return nil, fmt.Errorf("unknown timestamp source: %s", ci.config.TimestampSource)
+	}
+	ifindex := 0
+	name := "AF_PACKET"
+	if ci.config.Interface != "" {
+		intf, err := net.InterfaceByName(ci.config.Interface)
+		if err != nil {
+			return nil, err
+		}
+		ifindex = intf.Index
+		name += " " + intf.Name
+	}
+
+	// No packets are queued until the socket is bound below, after the filter is in place
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	r := &packetRing{
+		name:      name,
+		fd:        fd,
+		blockSize: blockSize,
+		blocks:    blocks,
+		hubClock:  ci.config.TimestampSource == "" || ci.config.TimestampSource == internal.TimestampSourceHub,
+		buf:       make([]byte, 65535),
+		drops:     ci.KernelDrops.WithLabelValues(name),
+	}
+	if err := r.setup(ci, ifindex); err != nil {
+		r.release()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *packetRing) setup(ci *CaptureInstance, ifindex int) error {
+	if err := ci.attachSocketFilter(r.fd, r.name, filterLayoutPacket); err != nil {
+		return err
+	}
+	if err := unix.SetsockoptInt(r.fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		return fmt.Errorf("failed to select TPACKET_V3: %w", err)
+	}
+	if ci.config.TimestampSource == internal.TimestampSourceHardware {
+		// Ring timestamps are software by default, ask for NIC timestamps where the driver has them enabled
+		if err := unix.SetsockoptInt(r.fd, unix.SOL_PACKET, unix.PACKET_TIMESTAMP, unix.SOF_TIMESTAMPING_RAW_HARDWARE); err != nil {
+			ci.logger.Debug("hardware timestamps unavailable", "socket", r.name, "error", err)
+		}
+	}
+	req := unix.TpacketReq3{
+		Block_size:     uint32(r.blockSize),
+		Block_nr:       uint32(r.blocks),
+		Frame_size:     packetRingFrameSize,
+		Frame_nr:       uint32(r.blockSize / packetRingFrameSize * r.blocks),
+		Retire_blk_tov: packetRingRetireTimeout,
+	}
+	if err := unix.SetsockoptTpacketReq3(r.fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		return fmt.Errorf("failed to set up ring of %d x %d byte blocks: %w", r.blocks, r.blockSize, err)
+	}
+	ring, err := unix.Mmap(r.fd, 0, r.blockSize*r.blocks, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("failed to map ring: %w", err)
+	}
+	r.ring = ring
+	return unix.Bind(r.fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifindex})
+}
+
+func (r *packetRing) String() string {
+	return r.name
+}
+
+func (r *packetRing) next() (*rawPacket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		if r.closed.Load() {
+			r.release()
+		}
+		if r.ring == nil {
+			return nil, net.ErrClosed
+		}
+		if r.blockHdr != nil {
+			if r.pktsLeft > 0 {
+				return r.readFrame()
+			}
+			// Hand the block back to the kernel
+			atomic.StoreUint32(&r.blockHdr.Block_status, unix.TP_STATUS_KERNEL)
+			r.blockHdr = nil
+			r.block = (r.block + 1) % r.blocks
+			r.updateDrops()
+		}
+		hdr := (*unix.TpacketHdrV1)(unsafe.Pointer(&r.ring[r.block*r.blockSize+packetRingBlockHeaderOffset]))
+		if atomic.LoadUint32(&hdr.Block_status)&unix.TP_STATUS_USER == 0 {
+			fds := []unix.PollFd{{Fd: int32(r.fd), Events: unix.POLLIN | unix.POLLERR}}
+			if _, err := unix.Poll(fds, packetRingPollTimeout); err != nil && err != unix.EINTR {
+				return nil, err
+			}
+			continue
+		}
+		r.blockHdr = hdr
+		r.pktsLeft = hdr.Num_pkts
+		r.offset = int(hdr.Offset_to_first_pkt)
+	}
+}
+
+// readFrame copies the next packet out of the current block, so that it stays
+// valid after the block is handed back to the kernel
+func (r *packetRing) readFrame() (*rawPacket, error) {
+	base := r.block*r.blockSize + r.offset
+	hdr := (*unix.Tpacket3Hdr)(unsafe.Pointer(&r.ring[base]))
+	r.pktsLeft--
+	r.offset += int(hdr.Next_offset)
+
+	start := base + int(hdr.Net)
+	n := copy(r.buf, r.ring[start:start+int(hdr.Snaplen)])
+	data := r.buf[:n]
+	if r.hubClock {
+		r.pkt.timestamp, r.pkt.tsSource = time.Now(), internal.TimestampSourceHub
+	} else {
+		r.pkt.timestamp, r.pkt.tsSource = time.Unix(int64(hdr.Sec), int64(hdr.Nsec)), internal.TimestampSourceKernel
+		if hdr.Status&unix.TP_STATUS_TS_RAW_HARDWARE != 0 {
+			r.pkt.tsSource = internal.TimestampSourceHardware
+		}
+	}
+
+	var err error
+	r.pkt.src, r.pkt.data, err = stripIP(data)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected packet in ring: %w", err)
+	}
+	return &r.pkt, nil
+}
+
+// updateDrops adds the packets dropped because the ring was full since the last call
+func (r *packetRing) updateDrops() {
+	stats, err := unix.GetsockoptTpacketStatsV3(r.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	if err == nil {
+		r.drops.Add(float64(stats.Drops))
+	}
+}
+
+func (r *packetRing) close() {
+	r.closed.Store(true)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.release()
+}
+
+// release unmaps the ring and closes the socket, r.mu must be held once the ring is in use
+func (r *packetRing) release() {
+	if r.fd < 0 {
+		return
+	}
+	if r.ring != nil {
+		unix.Munmap(r.ring)
+		r.ring = nil
+	}
+	unix.Close(r.fd)
+	r.fd = -1
+}
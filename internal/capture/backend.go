@@ -0,0 +1,70 @@
+package capture
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+
+	"golang.org/x/sys/unix"
+)
+
+// Backend selects how GRE encapsulated mirror traffic is received
+type Backend string
+
+const (
+	BackendSocket   Backend = "socket"   // raw IPPROTO_GRE sockets, one packet per syscall
+	BackendAFPacket Backend = "afpacket" // AF_PACKET TPACKET_V3 mmap ring with an in-kernel GRE filter
+)
+
+// rawPacket is a GRE packet as received by a capture backend
+type rawPacket struct {
+	src       netip.Addr
+	data      []byte // starts at the GRE header
+	timestamp time.Time
+	tsSource  internal.TimestampSource
+}
+
+// captureBackend receives GRE packets for processGREPacket
+type captureBackend interface {
+	// String names the backend in logs and metrics
+	String() string
+	// next blocks until a GRE packet arrives. The returned packet is only valid
+	// until the following call.
+	next() (*rawPacket, error)
+	// close makes a blocked next return an error and releases the backend.
+	// It may be called while next is running in another goroutine.
+	close()
+}
+
+// openBackends opens the capture backends selected in the config
+func (ci *CaptureInstance) openBackends() ([]captureBackend, error) {
+	var backends []captureBackend
+	switch ci.config.Backend {
+	case "", BackendSocket:
+		families := []int{unix.AF_INET}
+		if ci.config.IPv6 {
+			families = append(families, unix.AF_INET6)
+		}
+		for _, family := range families {
+			sock, err := ci.openRawSocket(family)
+			if err != nil {
+				for _, b := range backends {
+					b.close()
+				}
+				return nil, fmt.Errorf("failed to open raw GRE %s socket: %w", familyName(family), err)
+			}
+			backends = append(backends, sock)
+		}
+	case BackendAFPacket:
+		ring, err := ci.openPacketRing()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open AF_PACKET ring: %w", err)
+		}
+		backends = append(backends, ring)
+	default:
+		return nil, fmt.Errorf("unknown capture backend: %s", ci.config.Backend)
+	}
+	return backends, nil
+}
@@ -0,0 +1,29 @@
+package capture
+
+import (
+	"net/netip"
+
+	"anthonyuk.dev/erspan-hub/internal"
+	"anthonyuk.dev/erspan-hub/internal/forward"
+)
+
+type Config struct {
+	Backend         Backend
+	Interface       string // interface for the afpacket backend, empty for all interfaces
+	RingBlockSize   uint32 // afpacket ring block size in bytes, a multiple of the page size
+	RingBlocks      uint32 // number of blocks in the afpacket ring
+	Workers         int    // goroutines forwarding packets, 1 forwards on the receiving goroutine
+	IPv6            bool   // also capture ERSPAN carried over IPv6
+	TypeISessionID  uint16 // ERSPAN ID assigned to Type I streams, which carry no session ID
+	TimestampSource internal.TimestampSource
+	VXLANPort       uint16  // UDP port for VXLAN mirror traffic, 0 to disable
+	TZSPPort        uint16  // UDP port for TZSP mirror traffic, 0 to disable
+	ReplayFile      string  // pcap or pcapng file to replay instead of capturing live traffic
+	ReplaySpeed     float64 // replay pacing, 1 is realtime, 0 as fast as possible
+	ReplayLoop      bool    // restart the replay at the end of the file
+	Forward         forward.Config
+	// Socket filter, an empty list allows everything
+	FilterSources      []netip.Prefix     // exporter prefixes
+	FilterGREProtocols []uint16           // GRE protocol types
+	FilterSessionIDs   []internal.IDRange // ERSPAN IDs, GRE keys for transparent Ethernet bridging
+}
@@ -3,7 +3,8 @@ package capture
 import (
 	"fmt"
 	"log/slog"
-	"net"
+	"net/netip"
+	"sync"
 	"time"
 
 	"anthonyuk.dev/erspan-hub/internal"
@@ -12,29 +13,36 @@ import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/prometheus/client_golang/prometheus"
-	"golang.org/x/sys/unix"
 )
 
 type CaptureInstance struct {
-	socket       int
-	buf          []byte
+	config       *Config
+	backends     []captureBackend
+	udpListeners []*udpListener
 	logger       *slog.Logger
 	fsmgr        *forward.ForwardSessionManager
 	shutdown     bool
-	TotalPackets prometheus.Counter
-	TotalBytes   prometheus.Counter
+	erspanClock  *erspanClock
+	workers      *workerPool
+	// filters attached to the capture sockets, for SocketFilters
+	filterMu      sync.Mutex
+	socketFilters []SocketFilter
+	TotalPackets  prometheus.Counter
+	TotalBytes    prometheus.Counter
+	KernelDrops   *prometheus.CounterVec
 }
 
-func NewCaptureInstance(logger *slog.Logger) *CaptureInstance {
+func NewCaptureInstance(cfg *Config, logger *slog.Logger) *CaptureInstance {
 	if logger != nil {
 		logger = logger.With("component", "capture")
 	} else {
 		logger = slog.New(nil)
 	}
 	ci := &CaptureInstance{
-		buf:    make([]byte, 65535),
-		logger: logger,
-		fsmgr:  forward.NewForwardSessionManager(logger),
+		config:      cfg,
+		logger:      logger,
+		fsmgr:       forward.NewForwardSessionManager(&cfg.Forward, logger),
+		erspanClock: newErspanClock(),
 		TotalPackets: prometheus.NewCounter(prometheus.CounterOpts{
 			Name: "total_packets",
 			Help: "Total ERSPAN packets captured",
@@ -43,92 +51,199 @@ func NewCaptureInstance(logger *slog.Logger) *CaptureInstance {
 			Name: "total_bytes",
 			Help: "Total ERSPAN bytes captured",
 		}),
+		KernelDrops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kernel_dropped_packets",
+			Help: "Packets dropped by the kernel because the capture socket was not read fast enough",
+		}, []string{"socket"}),
 	}
 	prometheus.MustRegister(ci.TotalPackets)
 	prometheus.MustRegister(ci.TotalBytes)
+	prometheus.MustRegister(ci.KernelDrops)
+	prometheus.MustRegister(forward.NewStreamCollector(ci.fsmgr))
 	return ci
 }
 
-// StartPacketCapture opens a raw GRE socket and starts the packet processing loop
+// setupTimestamps enables kernel receive timestamps on a socket if configured.
+// They are also used for packets without a usable ERSPAN timestamp in erspan mode.
+// If the kernel refuses, the socket falls back to the hub clock.
+func (ci *CaptureInstance) setupTimestamps(fd int, name string) (kernelTimestamping, error) {
+	switch ci.config.TimestampSource {
+	case "", internal.TimestampSourceHub:
+		return timestampingNone, nil
+	case internal.TimestampSourceKernel, internal.TimestampSourceHardware, internal.TimestampSourceErspan:
+		ts, err := enableKernelTimestamps(fd, ci.config.TimestampSource == internal.TimestampSourceHardware)
+		if err != nil {
+			ci.logger.Warn("kernel timestamps unavailable, using hub clock", "socket", name, "error", err)
+			return timestampingNone, nil
+		}
+		ci.logger.Info("kernel timestamps enabled", "socket", name, "option", ts)
+		return ts, nil
+	}
+	return timestampingNone, fmt.Errorf("unknown timestamp source: %s", ci.config.TimestampSource)
+}
+
+// StartPacketCapture opens the raw GRE sockets and runs a packet processing loop for each.
+// If a replay file is configured it is read instead and no sockets are opened.
 func (ci *CaptureInstance) StartPacketCapture() error {
-	// Open raw GRE socket
-	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_RAW, unix.IPPROTO_GRE)
+	done := make(chan struct{})
+	defer close(done)
+	go ci.fsmgr.RunReaper(done)
+	go ci.fsmgr.RunMergers(done)
+	if ci.config.Workers > 1 {
+		ci.workers = ci.startWorkers(ci.config.Workers)
+		defer ci.workers.stop()
+	}
+	if ci.config.ReplayFile != "" {
+		return ci.replayFile()
+	}
+	backends, err := ci.openBackends()
 	if err != nil {
-		ci.logger.Error("Failed to open raw GRE socket", "error", err)
+		ci.logger.Error("Failed to open capture backend", "backend", ci.config.Backend, "error", err)
 		return err
 	}
-	defer unix.Close(fd)
-	ci.socket = fd
+	ci.backends = backends
+	for _, b := range ci.backends {
+		ci.logger.Info("started packet capture", "protocol", "GRE", "socket", b.String())
+	}
+	for _, encap := range ci.udpEncaps() {
+		l, err := ci.openUDPListener(encap.name, encap.port, encap.decode)
+		if err != nil {
+			ci.logger.Error("Failed to open "+encap.name+" listener", "port", encap.port, "error", err)
+			ci.closeSockets()
+			return err
+		}
+		ci.udpListeners = append(ci.udpListeners, l)
+	}
 
-	ci.logger.Info("started packet capture", "protocol", "raw GRE")
+	wg := sync.WaitGroup{}
+	for _, b := range ci.backends {
+		wg.Add(1)
+		go func(b captureBackend) {
+			defer wg.Done()
+			ci.receiveLoop(b.String(), func() error { return ci.ProcessPacket(b) })
+		}(b)
+	}
+	for _, l := range ci.udpListeners {
+		wg.Add(1)
+		go func(l *udpListener) {
+			defer wg.Done()
+			ci.receiveLoop(l.name, func() error { return ci.processUDPPacket(l) })
+		}(l)
+	}
+	wg.Wait()
+	return nil
+}
 
-	// Main packet processing loop
+// receiveLoop is the main packet processing loop for one socket
+func (ci *CaptureInstance) receiveLoop(name string, process func() error) {
 	for {
 		if ci.shutdown {
-			return nil
+			return
 		}
-		if err := ci.ProcessPacket(); err != nil {
+		if err := process(); err != nil {
 			if ci.shutdown {
-				return nil
+				return
 			}
-			ci.logger.Warn("packet processing error", "error", err)
+			ci.logger.Warn("packet processing error", "socket", name, "error", err)
 		}
 	}
 }
 
+func (ci *CaptureInstance) closeSockets() {
+	for _, b := range ci.backends {
+		b.close()
+	}
+	for _, l := range ci.udpListeners {
+		l.conn.Close()
+	}
+}
+
 func (ci *CaptureInstance) Shutdown() {
 	ci.shutdown = true
-	unix.Close(ci.socket)
+	ci.closeSockets()
 }
 
-// ProcessPacket handles incoming raw GRE packets and forwards them to matching sessions
-func (ci *CaptureInstance) ProcessPacket() error {
-	n, from, err := unix.Recvfrom(ci.socket, ci.buf, 0)
+// ProcessPacket handles the next GRE packet from a capture backend and forwards it to matching sessions
+func (ci *CaptureInstance) ProcessPacket(b captureBackend) error {
+	p, err := b.next()
 	if err != nil {
 		return err
 	}
-	timestamp := time.Now()
-
-	src := from.(*unix.SockaddrInet4).Addr
-	srcIP := net.IPv4(src[0], src[1], src[2], src[3]).String()
-
-	// Parse the packet
-	packet := gopacket.NewPacket(ci.buf[:n], layers.LayerTypeIPv4, gopacket.Default)
+	return ci.processGRE(p.src, p.data, p.timestamp, p.tsSource)
+}
 
-	// Extract and validate GRE layer
-	gre := packet.Layer(layers.LayerTypeGRE)
-	if gre == nil {
-		ci.logger.Debug("non-GRE packet received, skipping",
-			"src_ip", srcIP,
-			"packet_length", n)
-		return fmt.Errorf("non-GRE packet received from %s", srcIP)
+// processGRE decapsulates a GRE packet from src, starting at the GRE header, and
+// forwards it to matching sessions. It is shared by the capture backends and file replay.
+func (ci *CaptureInstance) processGRE(src netip.Addr, data []byte, timestamp time.Time, tsSource internal.TimestampSource) error {
+	gre, payload, err := parseGRE(data)
+	if err != nil {
+		return fmt.Errorf("bad GRE packet from %s: %w", src, err)
 	}
 
-	greLayer := gre.(*layers.GRE)
-	if greLayer.Protocol != layers.EthernetTypeERSPAN {
-		ci.logger.Debug("non-ERSPAN GRE packet received, skipping",
-			"src_ip", srcIP,
-			"protocol", greLayer.Protocol,
-			"packet_length", n)
-		return nil
+	pi := &internal.PacketInfo{
+		Timestamp:       timestamp,
+		TimestampSource: tsSource,
+		HasSeq:          gre.hasSeq,
+		Seq:             gre.seq,
 	}
-
-	// Extract ERSPAN layer
-	erspan := packet.Layer(layers.LayerTypeERSPANII)
-	if erspan == nil {
-		ci.logger.Warn("missing ERSPAN layer, skipping",
-			"src_ip", srcIP,
-			"packet_length", n)
+	var inner []byte
+	switch gre.protocol {
+	case layers.EthernetTypeERSPAN:
+		if !gre.hasSeq {
+			// ERSPAN Type I has no sequence number and no ERSPAN header, the
+			// GRE payload is the mirrored Ethernet frame
+			pi.Key = internal.StreamKey{SrcIP: src, ErspanID: uint32(ci.config.TypeISessionID)}
+			pi.ErspanVersion = internal.ErspanTypeI
+			inner = payload
+			break
+		}
+		sessionID, frame, err := parseERSPANII(payload)
+		if err != nil {
+			return fmt.Errorf("bad ERSPAN packet from %s: %w", src, err)
+		}
+		pi.Key = internal.StreamKey{SrcIP: src, ErspanID: uint32(sessionID)}
+		pi.ErspanVersion = internal.ErspanTypeII
+		inner = frame
+	case EthernetTypeERSPANIII:
+		var erspan ERSPANIII
+		if err := erspan.DecodeFromBytes(payload, gopacket.NilDecodeFeedback); err != nil {
+			return fmt.Errorf("bad ERSPAN packet from %s: %w", src, err)
+		}
+		pi.Key = internal.StreamKey{SrcIP: src, ErspanID: uint32(erspan.SessionID)}
+		pi.ErspanVersion = internal.ErspanTypeIII
+		inner = erspan.Payload
+		if ci.config.TimestampSource == internal.TimestampSourceErspan {
+			if t, ok := ci.erspanClock.Time(pi.Key, &erspan, timestamp); ok {
+				pi.Timestamp, pi.TimestampSource = t, internal.TimestampSourceErspan
+			}
+		}
+	case layers.EthernetTypeTransparentEthernetBridging:
+		// Plain GRE bridging of Ethernet frames, streams are keyed by the GRE key if present
+		pi.Key = internal.StreamKey{SrcIP: src, ErspanID: gre.key, Encap: internal.EncapTEB}
+		inner = payload
+	default:
+		ci.logger.Debug("non-ERSPAN GRE packet received, skipping",
+			"src_ip", src,
+			"protocol", gre.protocol,
+			"packet_length", len(data))
 		return nil
 	}
+	ci.forwardPacket(pi, inner)
+	return nil
+}
 
-	erspanLayer := erspan.(*layers.ERSPANII)
-	key := internal.StreamKey{SrcIP: src, ErspanID: erspanLayer.SessionID}
-	inner := erspanLayer.Payload
+// forwardPacket accounts for a decapsulated packet and hands it to the forward session
+// manager, through the worker that owns its stream if there are several.
+// The packet is copied into a pooled buffer as the receive buffer is reused.
+func (ci *CaptureInstance) forwardPacket(pi *internal.PacketInfo, inner []byte) {
 	ci.TotalPackets.Inc()
 	ci.TotalBytes.Add(float64(len(inner)))
-	ci.fsmgr.ProcessPacket(key, timestamp, inner)
-	return nil
+	pb := internal.NewPacketBuffer(inner)
+	if ci.workers != nil {
+		ci.workers.dispatch(pi, pb)
+		return
+	}
+	ci.fsmgr.ProcessPacket(pi, pb)
 }
 
 func (ci *CaptureInstance) ForwardSessionManager() *forward.ForwardSessionManager {
@@ -0,0 +1,167 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// EthernetTypeERSPANIII is the GRE protocol type used by ERSPAN Type III
+const EthernetTypeERSPANIII layers.EthernetType = 0x22eb
+
+const (
+	erspan3HeaderLength    = 12
+	erspan3SubHeaderLength = 8
+)
+
+// ERSPANIIIFrameTypeEthernet is the FT value for a mirrored Ethernet frame
+const ERSPANIIIFrameTypeEthernet = 0
+
+// ERSPANIIIGranularity is the unit of the ERSPAN Type III hardware timestamp
+type ERSPANIIIGranularity uint8
+
+const (
+	ERSPANIIIGranularity100us    ERSPANIIIGranularity = 0
+	ERSPANIIIGranularity100ns    ERSPANIIIGranularity = 1
+	ERSPANIIIGranularityIEEE1588 ERSPANIIIGranularity = 2
+	ERSPANIIIGranularityUser     ERSPANIIIGranularity = 3
+)
+
+// TickDuration returns the duration of one timestamp tick, or 0 if the
+// granularity is user defined and therefore unknown to the hub
+func (g ERSPANIIIGranularity) TickDuration() time.Duration {
+	switch g {
+	case ERSPANIIIGranularity100us:
+		return 100 * time.Microsecond
+	case ERSPANIIIGranularity100ns:
+		return 100 * time.Nanosecond
+	case ERSPANIIIGranularityIEEE1588:
+		return time.Nanosecond
+	}
+	return 0
+}
+
+func (g ERSPANIIIGranularity) String() string {
+	switch g {
+	case ERSPANIIIGranularity100us:
+		return "100us"
+	case ERSPANIIIGranularity100ns:
+		return "100ns"
+	case ERSPANIIIGranularityIEEE1588:
+		return "IEEE 1588"
+	}
+	return "user defined"
+}
+
+// LayerTypeERSPANIII is registered with gopacket so that the GRE decoder hands
+// protocol 0x22eb payloads to DecodeFromBytes below
+var LayerTypeERSPANIII = gopacket.RegisterLayerType(2001, gopacket.LayerTypeMetadata{
+	Name:    "ERSPANIII",
+	Decoder: gopacket.DecodeFunc(decodeERSPANIII),
+})
+
+func init() {
+	layers.EthernetTypeMetadata[EthernetTypeERSPANIII] = layers.EnumMetadata{
+		DecodeWith: LayerTypeERSPANIII,
+		Name:       "ERSPAN Type III",
+		LayerType:  LayerTypeERSPANIII,
+	}
+}
+
+// ERSPANIII contains the fields of an ERSPAN Type III header and the optional
+// platform specific subheader
+// https://tools.ietf.org/html/draft-foschiano-erspan-03#section-4.3
+type ERSPANIII struct {
+	layers.BaseLayer
+	Version, CoS, BSO         uint8
+	TrunkEncap                bool
+	VLANIdentifier, SessionID uint16
+	Timestamp                 uint32
+	SGT                       uint16
+	IsPDU                     bool
+	FrameType, HardwareID     uint8
+	Egress                    bool
+	Granularity               ERSPANIIIGranularity
+	HasSubHeader              bool
+	PlatformID                uint8
+	PlatformInfo              uint64
+}
+
+func (e *ERSPANIII) LayerType() gopacket.LayerType { return LayerTypeERSPANIII }
+
+// DecodeFromBytes decodes the given bytes into this layer.
+func (e *ERSPANIII) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < erspan3HeaderLength {
+		df.SetTruncated()
+		return fmt.Errorf("ERSPAN Type III header too short: %d bytes", len(data))
+	}
+	e.Version = data[0] >> 4
+	e.VLANIdentifier = binary.BigEndian.Uint16(data[0:2]) & 0x0fff
+	e.CoS = data[2] >> 5
+	e.BSO = data[2] >> 3 & 0x3
+	e.TrunkEncap = data[2]&0x04 != 0
+	e.SessionID = binary.BigEndian.Uint16(data[2:4]) & 0x03ff
+	e.Timestamp = binary.BigEndian.Uint32(data[4:8])
+	e.SGT = binary.BigEndian.Uint16(data[8:10])
+	e.IsPDU = data[10]&0x80 != 0
+	e.FrameType = data[10] >> 2 & 0x1f
+	e.HardwareID = (data[10]&0x03)<<4 | data[11]>>4
+	e.Egress = data[11]&0x08 != 0
+	e.Granularity = ERSPANIIIGranularity(data[11] >> 1 & 0x3)
+	e.HasSubHeader = data[11]&0x01 != 0
+
+	length := erspan3HeaderLength
+	e.PlatformID = 0
+	e.PlatformInfo = 0
+	if e.HasSubHeader {
+		if len(data) < erspan3HeaderLength+erspan3SubHeaderLength {
+			df.SetTruncated()
+			return fmt.Errorf("ERSPAN Type III platform subheader too short: %d bytes", len(data))
+		}
+		sub := binary.BigEndian.Uint64(data[erspan3HeaderLength:])
+		e.PlatformID = uint8(sub >> 58)
+		e.PlatformInfo = sub & 0x03ffffffffffffff
+		length += erspan3SubHeaderLength
+	}
+	e.Contents = data[:length]
+	e.Payload = data[length:]
+	return nil
+}
+
+// TimestampSeconds returns the upper part of the hardware timestamp carried in
+// the platform specific subheader, for the platform IDs that define one
+func (e *ERSPANIII) TimestampSeconds() (uint32, bool) {
+	if !e.HasSubHeader {
+		return 0, false
+	}
+	switch e.PlatformID {
+	case 0x03, 0x05, 0x06:
+		return uint32(e.PlatformInfo), true
+	}
+	return 0, false
+}
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode.
+func (e *ERSPANIII) CanDecode() gopacket.LayerClass {
+	return LayerTypeERSPANIII
+}
+
+// NextLayerType returns the layer type contained by this DecodingLayer.
+func (e *ERSPANIII) NextLayerType() gopacket.LayerType {
+	if e.FrameType != ERSPANIIIFrameTypeEthernet {
+		return gopacket.LayerTypePayload
+	}
+	return layers.LayerTypeEthernet
+}
+
+func decodeERSPANIII(data []byte, p gopacket.PacketBuilder) error {
+	e := &ERSPANIII{}
+	if err := e.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(e)
+	return p.NextDecoder(e.NextLayerType())
+}
@@ -0,0 +1,443 @@
+package capture
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// filterLayout describes where a socket filter finds the packet headers
+type filterLayout int
+
+const (
+	filterLayoutPacket  filterLayout = iota // AF_PACKET SOCK_DGRAM, IPv4 or IPv6 header at offset 0
+	filterLayoutRawIPv4                     // raw IPv4 GRE socket, IPv4 header at offset 0
+	filterLayoutRawIPv6                     // raw IPv6 GRE socket, GRE header at offset 0
+)
+
+const (
+	// skfNetOff is SKF_NET_OFF, loads relative to it start at the network header
+	skfNetOff = 0xfff00000
+	// snap length returned by the filter for accepted packets
+	filterAccept = 0x40000
+	// scratch memory slots
+	filterMemGRE  = 0 // offset of the GRE header
+	filterMemCsum = 1 // length of the GRE checksum field
+	filterMemKey  = 2 // length of the GRE key field
+	// BPF_MAXINSNS
+	filterMaxInstructions = 4096
+	filterLabelNext       = ""
+)
+
+// SocketFilter is a classic BPF program attached to a capture socket
+type SocketFilter struct {
+	Socket       string               `json:"socket"`
+	Instructions []string             `json:"instructions"`
+	Program      []bpf.RawInstruction `json:"program"`
+}
+
+// filterJump is a conditional jump to be resolved once all labels are known
+type filterJump struct {
+	at        int
+	trueLabel string
+	elseLabel string
+}
+
+// filterBuilder assembles a classic BPF program with forward jumps to named labels
+type filterBuilder struct {
+	insns  []bpf.Instruction
+	labels map[string]int
+	jumps  []filterJump
+	gotos  map[int]string
+	n      int
+}
+
+func newFilterBuilder() *filterBuilder {
+	return &filterBuilder{labels: make(map[string]int), gotos: make(map[int]string)}
+}
+
+func (b *filterBuilder) emit(ins ...bpf.Instruction) {
+	b.insns = append(b.insns, ins...)
+}
+
+func (b *filterBuilder) label(name string) {
+	b.labels[name] = len(b.insns)
+}
+
+// newLabel returns a unique label name
+func (b *filterBuilder) newLabel() string {
+	b.n++
+	return fmt.Sprintf("L%d", b.n)
+}
+
+// jumpIf jumps to trueLabel if the condition holds and to elseLabel otherwise,
+// filterLabelNext continues with the next instruction
+func (b *filterBuilder) jumpIf(cond bpf.JumpTest, val uint32, trueLabel, elseLabel string) {
+	b.jumps = append(b.jumps, filterJump{at: len(b.insns), trueLabel: trueLabel, elseLabel: elseLabel})
+	b.emit(bpf.JumpIf{Cond: cond, Val: val})
+}
+
+func (b *filterBuilder) jump(label string) {
+	b.gotos[len(b.insns)] = label
+	b.emit(bpf.Jump{})
+}
+
+func (b *filterBuilder) skip(from int, label string) (uint32, error) {
+	if label == filterLabelNext {
+		return 0, nil
+	}
+	to, ok := b.labels[label]
+	if !ok || to <= from {
+		return 0, fmt.Errorf("bad jump to %s", label)
+	}
+	return uint32(to - from - 1), nil
+}
+
+// far reports whether a conditional jump cannot reach a label, its offsets are 8 bits
+func (b *filterBuilder) far(from int, label string) bool {
+	return label != filterLabelNext && b.labels[label]-from-1 > 255
+}
+
+// insert inserts n empty jumps at pos, moving the labels and jumps from pos on
+func (b *filterBuilder) insert(pos, n int) {
+	b.insns = slices.Insert(b.insns, pos, make([]bpf.Instruction, n)...)
+	for name, at := range b.labels {
+		if at >= pos {
+			b.labels[name] = at + n
+		}
+	}
+	for i := range b.jumps {
+		if b.jumps[i].at >= pos {
+			b.jumps[i].at += n
+		}
+	}
+	gotos := make(map[int]string, len(b.gotos))
+	for at, label := range b.gotos {
+		if at >= pos {
+			at += n
+		}
+		gotos[at] = label
+	}
+	b.gotos = gotos
+}
+
+// relax makes conditional jumps that cannot reach their label jump to an unconditional
+// jump to it, placed right after them. Inserting these moves other jumps further from
+// their labels, so it repeats until all jumps reach.
+func (b *filterBuilder) relax() {
+	for changed := true; changed; {
+		changed = false
+		for i := range b.jumps {
+			j := &b.jumps[i]
+			farTrue, farElse := b.far(j.at, j.trueLabel), b.far(j.at, j.elseLabel)
+			if !farTrue && !farElse {
+				continue
+			}
+			pos := j.at + 1
+			for _, label := range []*string{&j.trueLabel, &j.elseLabel} {
+				if *label == filterLabelNext {
+					*label = b.newLabel()
+					b.labels[*label] = pos
+				}
+			}
+			var far []*string
+			if farTrue {
+				far = append(far, &j.trueLabel)
+			}
+			if farElse {
+				far = append(far, &j.elseLabel)
+			}
+			b.insert(pos, len(far))
+			for k, label := range far {
+				b.gotos[pos+k] = *label
+				*label = b.newLabel()
+				b.labels[*label] = pos + k
+			}
+			changed = true
+		}
+	}
+}
+
+func (b *filterBuilder) program() ([]bpf.Instruction, error) {
+	b.relax()
+	for _, j := range b.jumps {
+		ins := b.insns[j.at].(bpf.JumpIf)
+		st, err := b.skip(j.at, j.trueLabel)
+		if err != nil {
+			return nil, err
+		}
+		sf, err := b.skip(j.at, j.elseLabel)
+		if err != nil {
+			return nil, err
+		}
+		ins.SkipTrue, ins.SkipFalse = uint8(st), uint8(sf)
+		b.insns[j.at] = ins
+	}
+	for at, label := range b.gotos {
+		s, err := b.skip(at, label)
+		if err != nil {
+			return nil, err
+		}
+		b.insns[at] = bpf.Jump{Skip: s}
+	}
+	if len(b.insns) > filterMaxInstructions {
+		return nil, fmt.Errorf("socket filter too large, use fewer prefixes or ranges")
+	}
+	return b.insns, nil
+}
+
+// hasSocketFilter reports whether the config restricts the GRE traffic to capture
+func (ci *CaptureInstance) hasSocketFilter() bool {
+	return len(ci.config.FilterSources) > 0 || len(ci.config.FilterGREProtocols) > 0 || len(ci.config.FilterSessionIDs) > 0
+}
+
+// socketFilter builds the classic BPF program that accepts the GRE packets
+// allowed by the config for a socket with the given layout
+func (ci *CaptureInstance) socketFilter(layout filterLayout) ([]bpf.Instruction, error) {
+	b := newFilterBuilder()
+	ipv4 := layout != filterLayoutRawIPv6
+	ipv6 := ci.config.IPv6 && layout != filterLayoutRawIPv4
+
+	if layout == filterLayoutPacket {
+		// skip packets sent by this host
+		b.emit(bpf.LoadExtension{Num: bpf.ExtType})
+		b.jumpIf(bpf.JumpEqual, unix.PACKET_OUTGOING, "reject", filterLabelNext)
+		b.emit(bpf.LoadExtension{Num: bpf.ExtProto})
+		b.jumpIf(bpf.JumpEqual, unix.ETH_P_IP, "ipv4", filterLabelNext)
+		if ipv6 {
+			b.jumpIf(bpf.JumpEqual, unix.ETH_P_IPV6, "ipv6", filterLabelNext)
+		}
+		b.jump("reject")
+	}
+
+	if ipv4 {
+		b.label("ipv4")
+		// later fragments carry no GRE header
+		b.emit(bpf.LoadAbsolute{Off: 6, Size: 2})
+		b.jumpIf(bpf.JumpBitsSet, 0x1fff, "reject", filterLabelNext)
+		if layout == filterLayoutPacket {
+			b.emit(bpf.LoadAbsolute{Off: 9, Size: 1})
+			b.jumpIf(bpf.JumpNotEqual, unix.IPPROTO_GRE, "reject", filterLabelNext)
+		}
+		ci.filterSources(b, false, 12)
+		b.emit(
+			bpf.LoadMemShift{Off: 0},
+			bpf.StoreScratch{Src: bpf.RegX, N: filterMemGRE},
+		)
+		if ipv6 {
+			b.jump("gre")
+		}
+	}
+
+	if ipv6 {
+		b.label("ipv6")
+		var netOff, greOff uint32
+		switch layout {
+		case filterLayoutPacket:
+			// IPv6 extension headers are not supported, see stripIPv6
+			b.emit(bpf.LoadAbsolute{Off: 6, Size: 1})
+			b.jumpIf(bpf.JumpNotEqual, unix.IPPROTO_GRE, "reject", filterLabelNext)
+			greOff = 40
+		case filterLayoutRawIPv6:
+			// the IPv6 header has been pulled, it is reached through SKF_NET_OFF
+			netOff = skfNetOff
+		}
+		ci.filterSources(b, true, netOff+8)
+		b.emit(
+			bpf.LoadConstant{Dst: bpf.RegX, Val: greOff},
+			bpf.StoreScratch{Src: bpf.RegX, N: filterMemGRE},
+		)
+	}
+
+	b.label("gre")
+	b.emit(
+		bpf.LoadScratch{Dst: bpf.RegX, N: filterMemGRE},
+		bpf.LoadIndirect{Off: 2, Size: 2},
+	)
+	if len(ci.config.FilterGREProtocols) > 0 {
+		for _, proto := range ci.config.FilterGREProtocols {
+			b.jumpIf(bpf.JumpEqual, uint32(proto), "protocol", filterLabelNext)
+		}
+		b.jump("reject")
+		b.label("protocol")
+	}
+	if len(ci.config.FilterSessionIDs) > 0 {
+		ci.filterSessionIDs(b)
+	}
+	b.label("accept")
+	b.emit(bpf.RetConstant{Val: filterAccept})
+	b.label("reject")
+	b.emit(bpf.RetConstant{Val: 0})
+	return b.program()
+}
+
+// filterSources accepts the source addresses of one family in the allowed prefixes,
+// the address is loaded from off
+func (ci *CaptureInstance) filterSources(b *filterBuilder, ipv6 bool, off uint32) {
+	if len(ci.config.FilterSources) == 0 {
+		return
+	}
+	done := b.newLabel()
+	for _, prefix := range ci.config.FilterSources {
+		prefix = prefix.Masked()
+		if prefix.Addr().Is6() != ipv6 {
+			continue
+		}
+		next := b.newLabel()
+		addr := prefix.Addr().AsSlice()
+		bits := prefix.Bits()
+		for w := 0; w < len(addr)/4 && bits > 0; w++ {
+			val := uint32(addr[w*4])<<24 | uint32(addr[w*4+1])<<16 | uint32(addr[w*4+2])<<8 | uint32(addr[w*4+3])
+			b.emit(bpf.LoadAbsolute{Off: off + uint32(w*4), Size: 4})
+			if bits < 32 {
+				b.emit(bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: ^uint32(0) << (32 - bits)})
+			}
+			b.jumpIf(bpf.JumpNotEqual, val, next, filterLabelNext)
+			bits -= 32
+		}
+		b.jump(done)
+		b.label(next)
+	}
+	b.jump("reject")
+	b.label(done)
+}
+
+// filterSessionIDs accepts the packets whose session ID is in the allowed ranges.
+// A is the GRE protocol type and X the offset of the GRE header.
+func (ci *CaptureInstance) filterSessionIDs(b *filterBuilder) {
+	b.jumpIf(bpf.JumpEqual, uint32(layers.EthernetTypeERSPAN), "erspan", filterLabelNext)
+	b.jumpIf(bpf.JumpEqual, uint32(EthernetTypeERSPANIII), "erspanHeader", filterLabelNext)
+	b.jumpIf(bpf.JumpEqual, uint32(layers.EthernetTypeTransparentEthernetBridging), "teb", filterLabelNext)
+	// other protocols have no session ID
+	b.jump("accept")
+
+	// ERSPAN Type I has no sequence number and takes its session ID from the config
+	b.label("erspan")
+	b.emit(bpf.LoadIndirect{Off: 0, Size: 1})
+	b.jumpIf(bpf.JumpBitsSet, greFlagSeq, "erspanHeader", filterLabelNext)
+	ci.filterStaticID(b, uint32(ci.config.TypeISessionID))
+
+	// Type II and III, the ERSPAN header follows the optional GRE fields
+	b.label("erspanHeader")
+	b.emit(
+		bpf.LoadIndirect{Off: 0, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: greFlagChecksum},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 5},
+		bpf.StoreScratch{Src: bpf.RegA, N: filterMemCsum},
+		bpf.LoadIndirect{Off: 0, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: greFlagKey},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 3},
+		bpf.StoreScratch{Src: bpf.RegA, N: filterMemKey},
+		bpf.LoadIndirect{Off: 0, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: greFlagSeq},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 2},
+		bpf.LoadScratch{Dst: bpf.RegX, N: filterMemCsum},
+		bpf.ALUOpX{Op: bpf.ALUOpAdd},
+		bpf.LoadScratch{Dst: bpf.RegX, N: filterMemKey},
+		bpf.ALUOpX{Op: bpf.ALUOpAdd},
+		bpf.LoadScratch{Dst: bpf.RegX, N: filterMemGRE},
+		bpf.ALUOpX{Op: bpf.ALUOpAdd},
+		bpf.TAX{},
+		bpf.LoadIndirect{Off: 4 + 2, Size: 2},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0x03ff},
+	)
+	ci.filterIDRanges(b)
+
+	// Transparent Ethernet bridging is keyed by the GRE key, 0 without one
+	b.label("teb")
+	b.emit(bpf.LoadIndirect{Off: 0, Size: 1})
+	b.jumpIf(bpf.JumpBitsSet, greFlagKey, filterLabelNext, "tebNoKey")
+	b.emit(
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: greFlagChecksum},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 5},
+		bpf.ALUOpX{Op: bpf.ALUOpAdd},
+		bpf.TAX{},
+		bpf.LoadIndirect{Off: 4, Size: 4},
+	)
+	ci.filterIDRanges(b)
+	b.label("tebNoKey")
+	ci.filterStaticID(b, 0)
+}
+
+// filterIDRanges accepts the packet if the session ID in A is in an allowed range
+func (ci *CaptureInstance) filterIDRanges(b *filterBuilder) {
+	for _, r := range ci.config.FilterSessionIDs {
+		next := b.newLabel()
+		b.jumpIf(bpf.JumpLessThan, r.First, next, filterLabelNext)
+		b.jumpIf(bpf.JumpLessOrEqual, r.Last, "accept", filterLabelNext)
+		b.label(next)
+	}
+	b.jump("reject")
+}
+
+// filterStaticID accepts or rejects a packet with a session ID known when the filter is built
+func (ci *CaptureInstance) filterStaticID(b *filterBuilder, id uint32) {
+	for _, r := range ci.config.FilterSessionIDs {
+		if r.Contains(id) {
+			b.jump("accept")
+			return
+		}
+	}
+	b.jump("reject")
+}
+
+// attachSocketFilter builds and attaches the socket filter and records it for SocketFilters
+func (ci *CaptureInstance) attachSocketFilter(fd int, name string, layout filterLayout) error {
+	prog, err := ci.socketFilter(layout)
+	if err != nil {
+		return err
+	}
+	raw, err := attachFilter(fd, prog)
+	if err != nil {
+		return fmt.Errorf("failed to attach socket filter: %w", err)
+	}
+	sf := SocketFilter{Socket: name, Program: raw}
+	for _, ins := range prog {
+		sf.Instructions = append(sf.Instructions, fmt.Sprint(ins))
+	}
+	ci.filterMu.Lock()
+	ci.socketFilters = append(ci.socketFilters, sf)
+	ci.filterMu.Unlock()
+	ci.logger.Info("attached socket filter", "socket", name, "instructions", len(prog))
+	return nil
+}
+
+// SocketFilters returns the filters attached to the capture sockets
+func (ci *CaptureInstance) SocketFilters() []SocketFilter {
+	ci.filterMu.Lock()
+	defer ci.filterMu.Unlock()
+	return append([]SocketFilter{}, ci.socketFilters...)
+}
+
+// attachFilter attaches a classic BPF program to a socket
+func attachFilter(fd int, prog []bpf.Instruction) ([]bpf.RawInstruction, error) {
+	raw, err := bpf.Assemble(prog)
+	if err != nil {
+		return nil, err
+	}
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	return raw, unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	})
+}
+
+// ParseGREProtocols parses GRE protocol types given in decimal or as 0x hex
+func ParseGREProtocols(list []string) ([]uint16, error) {
+	protos := make([]uint16, 0, len(list))
+	for _, s := range list {
+		p, err := strconv.ParseUint(s, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GRE protocol type %q", s)
+		}
+		protos = append(protos, uint16(p))
+	}
+	return protos, nil
+}
@@ -0,0 +1,86 @@
+package capture
+
+import (
+	"net/netip"
+	"testing"
+
+	"anthonyuk.dev/erspan-hub/internal"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// runFilter builds the raw IPv4 socket filter for cfg and reports whether it accepts each packet
+func runFilter(t *testing.T, cfg *Config, packets map[string][]byte) map[string]bool {
+	t.Helper()
+	ci := &CaptureInstance{config: cfg}
+	prog, err := ci.socketFilter(filterLayoutRawIPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := bpf.NewVM(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(map[string]bool, len(packets))
+	for name, data := range packets {
+		n, err := vm.Run(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		accepted[name] = n > 0
+	}
+	return accepted
+}
+
+func TestSocketFilterManyRanges(t *testing.T) {
+	// Far more ranges than conditional jumps can skip over to reach accept and reject
+	cfg := &Config{}
+	for id := uint32(0); id < 1000; id += 2 {
+		cfg.FilterSessionIDs = append(cfg.FilterSessionIDs, internal.IDRange{First: id, Last: id})
+	}
+	erspan := func(id uint16) []byte {
+		return ipv4Packet(layers.IPProtocolGRE, grePacket(greFlagSeq, layers.EthernetTypeERSPAN, 0, 1, erspanIIPacket(id, testFrame)))
+	}
+	teb := func(key uint32) []byte {
+		return ipv4Packet(layers.IPProtocolGRE, grePacket(greFlagChecksum|greFlagKey, layers.EthernetTypeTransparentEthernetBridging, key, 0, testFrame))
+	}
+	got := runFilter(t, cfg, map[string][]byte{
+		"first":    erspan(0),
+		"last":     erspan(998),
+		"odd":      erspan(999),
+		"teb last": teb(998),
+		"teb odd":  teb(501),
+		"type I":   ipv4Packet(layers.IPProtocolGRE, grePacket(0, layers.EthernetTypeERSPAN, 0, 0, testFrame)),
+	})
+	want := map[string]bool{"first": true, "last": true, "odd": false, "teb last": true, "teb odd": false, "type I": true}
+	for name, accepted := range want {
+		if got[name] != accepted {
+			t.Errorf("%s: accepted = %v, want %v", name, got[name], accepted)
+		}
+	}
+}
+
+func TestSocketFilterManyPrefixes(t *testing.T) {
+	cfg := &Config{IPv6: true}
+	for i := range 200 {
+		cfg.FilterSources = append(cfg.FilterSources,
+			netip.PrefixFrom(netip.AddrFrom4([4]byte{192, 168, byte(i), 0}), 24),
+			netip.PrefixFrom(netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, byte(i)}), 48))
+	}
+	// testSrc 10.1.2.3 is the last prefix
+	cfg.FilterSources = append(cfg.FilterSources, netip.MustParsePrefix("10.1.2.0/24"))
+	packet := ipv4Packet(layers.IPProtocolGRE, grePacket(0, layers.EthernetTypeERSPAN, 0, 0, testFrame))
+	other := bytesWithSource(packet, [4]byte{10, 1, 3, 3})
+	got := runFilter(t, cfg, map[string][]byte{"allowed": packet, "other": other})
+	if !got["allowed"] || got["other"] {
+		t.Errorf("allowed = %v, other = %v", got["allowed"], got["other"])
+	}
+}
+
+// bytesWithSource returns a copy of an IPv4 packet with another source address
+func bytesWithSource(packet []byte, src [4]byte) []byte {
+	p := append([]byte{}, packet...)
+	copy(p[12:16], src[:])
+	return p
+}
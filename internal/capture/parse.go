@@ -0,0 +1,139 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/google/gopacket/layers"
+)
+
+// Header parsing for the capture fast path. The headers are read at fixed
+// offsets without allocating, gopacket is only used to decode replayed files.
+
+const (
+	ipv4MinHeaderLength = 20
+	ipv6HeaderLength    = 40
+	greMinHeaderLength  = 4
+	erspan2HeaderLength = 8
+)
+
+const (
+	greFlagChecksum = 0x80
+	greFlagRouting  = 0x40
+	greFlagKey      = 0x20
+	greFlagSeq      = 0x10
+)
+
+// greHeader is the part of a GRE header the hub uses
+type greHeader struct {
+	protocol layers.EthernetType
+	hasKey   bool
+	hasSeq   bool
+	key      uint32
+	seq      uint32
+}
+
+var errNotGRE = errors.New("not a GRE packet")
+
+// stripIP returns the source address and the payload of an IPv4 or IPv6 GRE packet
+func stripIP(data []byte) (netip.Addr, []byte, error) {
+	if len(data) > 0 && data[0]>>4 == 6 {
+		return stripIPv6(data)
+	}
+	return stripIPv4(data)
+}
+
+// stripIPv4 returns the source address and the payload of an IPv4 GRE packet
+func stripIPv4(data []byte) (netip.Addr, []byte, error) {
+	if len(data) < ipv4MinHeaderLength || data[0]>>4 != 4 {
+		return netip.Addr{}, nil, fmt.Errorf("IPv4 header too short: %d bytes", len(data))
+	}
+	ihl := int(data[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(data[2:4]))
+	if ihl < ipv4MinHeaderLength || len(data) < ihl {
+		return netip.Addr{}, nil, fmt.Errorf("bad IPv4 header length: %d", ihl)
+	}
+	src := netip.AddrFrom4([4]byte(data[12:16]))
+	if data[9] != byte(layers.IPProtocolGRE) {
+		return src, nil, errNotGRE
+	}
+	// Drop Ethernet padding, the kernel may report a total length of 0 for TSO packets
+	if total >= ihl && total < len(data) {
+		data = data[:total]
+	}
+	return src, data[ihl:], nil
+}
+
+// stripIPv6 returns the source address and the payload of an IPv6 GRE packet.
+// Extension headers are not supported.
+func stripIPv6(data []byte) (netip.Addr, []byte, error) {
+	if len(data) < ipv6HeaderLength || data[0]>>4 != 6 {
+		return netip.Addr{}, nil, fmt.Errorf("IPv6 header too short: %d bytes", len(data))
+	}
+	src := netip.AddrFrom16([16]byte(data[8:24]))
+	if data[6] != byte(layers.IPProtocolGRE) {
+		return src, nil, errNotGRE
+	}
+	payload := int(binary.BigEndian.Uint16(data[4:6]))
+	data = data[ipv6HeaderLength:]
+	if payload > 0 && payload < len(data) {
+		data = data[:payload]
+	}
+	return src, data, nil
+}
+
+// parseGRE parses a GRE header (RFC 2784, RFC 2890) and returns it with the GRE payload
+func parseGRE(data []byte) (greHeader, []byte, error) {
+	var h greHeader
+	if len(data) < greMinHeaderLength {
+		return h, nil, fmt.Errorf("GRE header too short: %d bytes", len(data))
+	}
+	flags := data[0]
+	if version := data[1] & 0x07; version != 0 {
+		return h, nil, fmt.Errorf("unsupported GRE version %d", version)
+	}
+	if flags&greFlagRouting != 0 {
+		return h, nil, errors.New("GRE source routing is not supported")
+	}
+	h.protocol = layers.EthernetType(binary.BigEndian.Uint16(data[2:4]))
+	length := greMinHeaderLength
+	if flags&greFlagChecksum != 0 {
+		length += 4
+	}
+	if flags&greFlagKey != 0 {
+		length += 4
+	}
+	if flags&greFlagSeq != 0 {
+		length += 4
+	}
+	if len(data) < length {
+		return h, nil, fmt.Errorf("GRE header too short: %d bytes", len(data))
+	}
+	off := greMinHeaderLength
+	if flags&greFlagChecksum != 0 {
+		off += 4
+	}
+	if flags&greFlagKey != 0 {
+		h.hasKey = true
+		h.key = binary.BigEndian.Uint32(data[off:])
+		off += 4
+	}
+	if flags&greFlagSeq != 0 {
+		h.hasSeq = true
+		h.seq = binary.BigEndian.Uint32(data[off:])
+	}
+	return h, data[length:], nil
+}
+
+// parseERSPANII returns the session ID and the mirrored frame of an ERSPAN Type II packet
+func parseERSPANII(data []byte) (uint16, []byte, error) {
+	if len(data) < erspan2HeaderLength {
+		return 0, nil, fmt.Errorf("ERSPAN Type II header too short: %d bytes", len(data))
+	}
+	if version := data[0] >> 4; version != 1 {
+		return 0, nil, fmt.Errorf("unexpected ERSPAN Type II version %d", version)
+	}
+	return binary.BigEndian.Uint16(data[2:4]) & 0x03ff, data[erspan2HeaderLength:], nil
+}
@@ -0,0 +1,339 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net/netip"
+	"testing"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	testSrc   = netip.MustParseAddr("10.1.2.3")
+	testSrc6  = netip.MustParseAddr("2001:db8::3")
+	testFrame = mirroredFrame()
+)
+
+// mirroredFrame returns an Ethernet/IPv4/UDP frame as carried by ERSPAN
+func mirroredFrame() []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       []byte{0, 1, 2, 3, 4, 5},
+		DstMAC:       []byte{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: []byte{192, 0, 2, 1}, DstIP: []byte{192, 0, 2, 2}}
+	udp := &layers.UDP{SrcPort: 5000, DstPort: 5001}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(bytes.Repeat([]byte{0xab}, 32))); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// ipv4Packet wraps a payload in an IPv4 header from testSrc
+func ipv4Packet(proto layers.IPProtocol, payload []byte) []byte {
+	h := make([]byte, ipv4MinHeaderLength, ipv4MinHeaderLength+len(payload))
+	h[0] = 0x45
+	binary.BigEndian.PutUint16(h[2:4], uint16(ipv4MinHeaderLength+len(payload)))
+	h[8] = 64
+	h[9] = byte(proto)
+	copy(h[12:16], testSrc.AsSlice())
+	copy(h[16:20], []byte{10, 0, 0, 1})
+	return append(h, payload...)
+}
+
+// ipv6Packet wraps a payload in an IPv6 header from testSrc6
+func ipv6Packet(next layers.IPProtocol, payload []byte) []byte {
+	h := make([]byte, ipv6HeaderLength, ipv6HeaderLength+len(payload))
+	h[0] = 0x60
+	binary.BigEndian.PutUint16(h[4:6], uint16(len(payload)))
+	h[6] = byte(next)
+	h[7] = 64
+	copy(h[8:24], testSrc6.AsSlice())
+	return append(h, payload...)
+}
+
+// grePacket builds a GRE header with the optional fields of flags, followed by payload
+func grePacket(flags byte, proto layers.EthernetType, key, seq uint32, payload []byte) []byte {
+	h := []byte{flags, 0, 0, 0}
+	binary.BigEndian.PutUint16(h[2:4], uint16(proto))
+	if flags&greFlagChecksum != 0 {
+		h = append(h, 0xde, 0xad, 0, 0)
+	}
+	if flags&greFlagKey != 0 {
+		h = binary.BigEndian.AppendUint32(h, key)
+	}
+	if flags&greFlagSeq != 0 {
+		h = binary.BigEndian.AppendUint32(h, seq)
+	}
+	return append(h, payload...)
+}
+
+// erspanIIPacket builds an ERSPAN Type II header followed by payload
+func erspanIIPacket(sessionID uint16, payload []byte) []byte {
+	h := make([]byte, erspan2HeaderLength, erspan2HeaderLength+len(payload))
+	binary.BigEndian.PutUint16(h[0:2], 1<<12|100) // version 1, VLAN 100
+	binary.BigEndian.PutUint16(h[2:4], sessionID&0x03ff)
+	return append(h, payload...)
+}
+
+// erspanIIIPacket builds an ERSPAN Type III header without a subheader followed by payload
+func erspanIIIPacket(sessionID uint16, payload []byte) []byte {
+	h := make([]byte, erspan3HeaderLength, erspan3HeaderLength+len(payload))
+	binary.BigEndian.PutUint16(h[0:2], 2<<12|100) // version 2, VLAN 100
+	binary.BigEndian.PutUint16(h[2:4], sessionID&0x03ff)
+	binary.BigEndian.PutUint32(h[4:8], 123456)
+	h[11] = byte(ERSPANIIIGranularityIEEE1588) << 1
+	return append(h, payload...)
+}
+
+// decodeFast decodes an IPv4/GRE/ERSPAN packet the way the capture path does
+func decodeFast(data []byte) (netip.Addr, uint16, []byte, error) {
+	src, data, err := stripIPv4(data)
+	if err != nil {
+		return src, 0, nil, err
+	}
+	gre, payload, err := parseGRE(data)
+	if err != nil {
+		return src, 0, nil, err
+	}
+	if gre.protocol == EthernetTypeERSPANIII {
+		var erspan ERSPANIII
+		if err := erspan.DecodeFromBytes(payload, gopacket.NilDecodeFeedback); err != nil {
+			return src, 0, nil, err
+		}
+		return src, erspan.SessionID, erspan.Payload, nil
+	}
+	id, frame, err := parseERSPANII(payload)
+	return src, id, frame, err
+}
+
+func benchmarkFrames() []struct {
+	name string
+	data []byte
+} {
+	return []struct {
+		name string
+		data []byte
+	}{
+		{"erspan2", ipv4Packet(layers.IPProtocolGRE, grePacket(greFlagSeq, layers.EthernetTypeERSPAN, 0, 7, erspanIIPacket(42, testFrame)))},
+		{"erspan3", ipv4Packet(layers.IPProtocolGRE, grePacket(greFlagSeq, EthernetTypeERSPANIII, 0, 7, erspanIIIPacket(42, testFrame)))},
+	}
+}
+
+func BenchmarkParseGRE(b *testing.B) {
+	for _, f := range benchmarkFrames() {
+		b.Run(f.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(f.data)))
+			for b.Loop() {
+				if _, _, _, err := decodeFast(f.data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGopacketNewPacket(b *testing.B) {
+	for _, f := range benchmarkFrames() {
+		b.Run(f.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(f.data)))
+			for b.Loop() {
+				p := gopacket.NewPacket(f.data, layers.LayerTypeIPv4, gopacket.Default)
+				if p.ErrorLayer() != nil {
+					b.Fatal(p.ErrorLayer().Error())
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeFast(t *testing.T) {
+	for _, f := range benchmarkFrames() {
+		src, id, frame, err := decodeFast(f.data)
+		if err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		if src != testSrc || id != 42 || !bytes.Equal(frame, testFrame) {
+			t.Errorf("%s: got %s, %d, %d bytes", f.name, src, id, len(frame))
+		}
+	}
+}
+
+func TestParseGRE(t *testing.T) {
+	const key, seq = 0x01020304, 0x0a0b0c0d
+	tests := []struct {
+		name  string
+		flags byte
+	}{
+		{"none", 0},
+		{"checksum", greFlagChecksum},
+		{"key", greFlagKey},
+		{"seq", greFlagSeq},
+		{"checksum key", greFlagChecksum | greFlagKey},
+		{"checksum seq", greFlagChecksum | greFlagSeq},
+		{"key seq", greFlagKey | greFlagSeq},
+		{"checksum key seq", greFlagChecksum | greFlagKey | greFlagSeq},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := grePacket(tt.flags, layers.EthernetTypeERSPAN, key, seq, testFrame)
+			h, payload, err := parseGRE(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantKey, wantSeq := tt.flags&greFlagKey != 0, tt.flags&greFlagSeq != 0
+			if h.protocol != layers.EthernetTypeERSPAN {
+				t.Errorf("protocol = %s", h.protocol)
+			}
+			if h.hasKey != wantKey || (wantKey && h.key != key) {
+				t.Errorf("key = %v %#x", h.hasKey, h.key)
+			}
+			if h.hasSeq != wantSeq || (wantSeq && h.seq != seq) {
+				t.Errorf("seq = %v %#x", h.hasSeq, h.seq)
+			}
+			if !bytes.Equal(payload, testFrame) {
+				t.Errorf("payload is %d bytes, want %d", len(payload), len(testFrame))
+			}
+
+			// Every truncation of the header must fail
+			header := len(data) - len(testFrame)
+			for n := 0; n < header; n++ {
+				if _, _, err := parseGRE(data[:n]); err == nil {
+					t.Errorf("%d of %d header bytes: no error", n, header)
+				}
+			}
+		})
+	}
+}
+
+func TestParseGREUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"version 1", []byte{0, 1, 0x88, 0xbe}},
+		{"routing", []byte{greFlagRouting, 0, 0x88, 0xbe, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		if _, _, err := parseGRE(tt.data); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestStripIPv4(t *testing.T) {
+	gre := grePacket(0, layers.EthernetTypeERSPAN, 0, 0, testFrame)
+	packet := ipv4Packet(layers.IPProtocolGRE, gre)
+
+	withOptions := ipv4Packet(layers.IPProtocolGRE, append([]byte{1, 1, 1, 0}, gre...))
+	withOptions[0] = 0x46
+
+	tsoLength := bytes.Clone(packet)
+	binary.BigEndian.PutUint16(tsoLength[2:4], 0)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr error
+		anyErr  bool
+	}{
+		{name: "plain", data: packet, want: gre},
+		{name: "options", data: withOptions, want: gre},
+		{name: "padding", data: append(bytes.Clone(packet), 0, 0, 0, 0), want: gre},
+		{name: "total length 0", data: tsoLength, want: gre},
+		{name: "not GRE", data: ipv4Packet(layers.IPProtocolUDP, gre), wantErr: errNotGRE},
+		{name: "empty", data: nil, anyErr: true},
+		{name: "truncated", data: packet[:ipv4MinHeaderLength-1], anyErr: true},
+		{name: "truncated options", data: withOptions[:ipv4MinHeaderLength+2], anyErr: true},
+		{name: "IHL too small", data: append([]byte{0x44}, packet[1:]...), anyErr: true},
+		{name: "IPv6", data: ipv6Packet(layers.IPProtocolGRE, gre), anyErr: true},
+	}
+	for _, tt := range tests {
+		src, payload, err := stripIPv4(tt.data)
+		switch {
+		case tt.wantErr != nil:
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: error %v, want %v", tt.name, err, tt.wantErr)
+			}
+		case tt.anyErr:
+			if err == nil {
+				t.Errorf("%s: no error", tt.name)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case src != testSrc || !bytes.Equal(payload, tt.want):
+			t.Errorf("%s: got %s with %d bytes, want %d", tt.name, src, len(payload), len(tt.want))
+		}
+	}
+}
+
+func TestStripIPv6(t *testing.T) {
+	gre := grePacket(0, layers.EthernetTypeERSPAN, 0, 0, testFrame)
+	packet := ipv6Packet(layers.IPProtocolGRE, gre)
+
+	src, payload, err := stripIP(append(bytes.Clone(packet), 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src != testSrc6 || !bytes.Equal(payload, gre) {
+		t.Errorf("got %s with %d bytes, want %d", src, len(payload), len(gre))
+	}
+	if _, _, err := stripIPv6(ipv6Packet(layers.IPProtocolUDP, gre)); !errors.Is(err, errNotGRE) {
+		t.Errorf("UDP: error %v, want %v", err, errNotGRE)
+	}
+	if _, _, err := stripIPv6(packet[:ipv6HeaderLength-1]); err == nil {
+		t.Error("truncated: no error")
+	}
+}
+
+func TestParseERSPANII(t *testing.T) {
+	packet := erspanIIPacket(0x3ff, testFrame)
+	id, frame, err := parseERSPANII(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 0x3ff || !bytes.Equal(frame, testFrame) {
+		t.Errorf("got %d with %d bytes", id, len(frame))
+	}
+	for n := 0; n < erspan2HeaderLength; n++ {
+		if _, _, err := parseERSPANII(packet[:n]); err == nil {
+			t.Errorf("%d header bytes: no error", n)
+		}
+	}
+	if _, _, err := parseERSPANII(erspanIIIPacket(1, testFrame)); err == nil {
+		t.Error("Type III header: no error")
+	}
+}
+
+func BenchmarkProcessGRE(b *testing.B) {
+	ci := NewCaptureInstance(&Config{}, slog.New(slog.DiscardHandler))
+	for _, f := range benchmarkFrames() {
+		_, gre, err := stripIPv4(f.data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(f.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(f.data)))
+			now := time.Now()
+			for b.Loop() {
+				if err := ci.processGRE(testSrc, gre, now, internal.TimestampSourceHub); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
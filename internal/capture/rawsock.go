@@ -0,0 +1,183 @@
+package capture
+
+import (
+	"fmt"
+	"net/netip"
+	"unsafe"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+// oob space for the SO_RXQ_OVFL drop counter
+var rxqOverflowOobLen = unix.CmsgSpace(4)
+
+const (
+	// packets read per recvmmsg call
+	rawSocketBatch   = 32
+	rawSocketBufSize = 65535
+)
+
+// mmsghdr is struct mmsghdr from recvmmsg(2)
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// rawSocket is the raw GRE socket backend for one address family. Packets are
+// read in batches with recvmmsg and handed out one at a time by next.
+type rawSocket struct {
+	fd           int
+	family       int
+	timestamping kernelTimestamping
+	// rawSocketBatch receive buffers, control message buffers and source addresses
+	bufs   []byte
+	oobs   []byte
+	oobLen int
+	names  []unix.RawSockaddrAny
+	iovs   []unix.Iovec
+	msgs   []mmsghdr
+	// number of packets in the last batch and index of the next one to hand out
+	received int
+	cur      int
+	// last SO_RXQ_OVFL value, the kernel reports a running total
+	rxqDrops uint32
+	drops    prometheus.Counter
+	pkt      rawPacket
+}
+
+func (ci *CaptureInstance) openRawSocket(family int) (*rawSocket, error) {
+	fd, err := unix.Socket(family, unix.SOCK_RAW, unix.IPPROTO_GRE)
+	if err != nil {
+		return nil, err
+	}
+	sock := &rawSocket{
+		fd:     fd,
+		family: family,
+	}
+	if ci.hasSocketFilter() {
+		layout := filterLayoutRawIPv4
+		if family == unix.AF_INET6 {
+			layout = filterLayoutRawIPv6
+		}
+		if err := ci.attachSocketFilter(fd, sock.String(), layout); err != nil {
+			unix.Close(fd)
+			return nil, err
+		}
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RXQ_OVFL, 1); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to enable SO_RXQ_OVFL: %w", err)
+	}
+	sock.timestamping, err = ci.setupTimestamps(fd, sock.String())
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	oobLen := rxqOverflowOobLen
+	if sock.timestamping != timestampingNone {
+		oobLen += timestampOobLen
+	}
+	sock.allocBatch(oobLen)
+	sock.drops = ci.KernelDrops.WithLabelValues(sock.String())
+	return sock, nil
+}
+
+// allocBatch sets up the message headers for recvmmsg, each pointing at its own slot in the buffers
+func (s *rawSocket) allocBatch(oobLen int) {
+	s.bufs = make([]byte, rawSocketBatch*rawSocketBufSize)
+	s.oobs = make([]byte, rawSocketBatch*oobLen)
+	s.oobLen = oobLen
+	s.names = make([]unix.RawSockaddrAny, rawSocketBatch)
+	s.iovs = make([]unix.Iovec, rawSocketBatch)
+	s.msgs = make([]mmsghdr, rawSocketBatch)
+	for i := range s.msgs {
+		s.iovs[i].Base = &s.bufs[i*rawSocketBufSize]
+		s.iovs[i].SetLen(rawSocketBufSize)
+		hdr := &s.msgs[i].hdr
+		hdr.Name = (*byte)(unsafe.Pointer(&s.names[i]))
+		hdr.Iov = &s.iovs[i]
+		hdr.SetIovlen(1)
+		hdr.Control = &s.oobs[i*oobLen]
+	}
+}
+
+func (s *rawSocket) String() string {
+	return "GRE " + familyName(s.family)
+}
+
+// recvBatch blocks until at least one packet is available and reads up to rawSocketBatch packets
+func (s *rawSocket) recvBatch() error {
+	for i := range s.msgs {
+		hdr := &s.msgs[i].hdr
+		hdr.Namelen = unix.SizeofSockaddrAny
+		hdr.SetControllen(s.oobLen)
+		hdr.Flags = 0
+	}
+	n, _, errno := unix.Syscall6(unix.SYS_RECVMMSG, uintptr(s.fd),
+		uintptr(unsafe.Pointer(&s.msgs[0])), uintptr(len(s.msgs)), unix.MSG_WAITFORONE, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	s.received, s.cur = int(n), 0
+	return nil
+}
+
+func (s *rawSocket) next() (*rawPacket, error) {
+	if s.cur >= s.received {
+		if err := s.recvBatch(); err != nil {
+			return nil, err
+		}
+	}
+	i := s.cur
+	s.cur++
+	msg := &s.msgs[i]
+	data := s.bufs[i*rawSocketBufSize:][:msg.len]
+	oob := s.oobs[i*s.oobLen:][:msg.hdr.Controllen]
+
+	if total, ok := rxqOverflow(oob); ok {
+		s.drops.Add(float64(total - s.rxqDrops))
+		s.rxqDrops = total
+	}
+	s.pkt.timestamp, s.pkt.tsSource = receiveTime(s.timestamping, oob)
+
+	if s.family == unix.AF_INET6 {
+		// IPv6 raw sockets start at GRE, the source is only in the address
+		sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(&s.names[i]))
+		s.pkt.src = netip.AddrFrom16(sa.Addr)
+		s.pkt.data = data
+		return &s.pkt, nil
+	}
+	var err error
+	s.pkt.src, s.pkt.data, err = stripIPv4(data)
+	if err != nil {
+		return nil, err
+	}
+	return &s.pkt, nil
+}
+
+func (s *rawSocket) close() {
+	unix.Close(s.fd)
+}
+
+// rxqOverflow returns the number of packets the kernel has dropped on the socket
+// so far, from the SO_RXQ_OVFL control message of a packet
+func rxqOverflow(oob []byte) (uint32, bool) {
+	c := cmsgs(oob)
+	for {
+		level, typ, data, ok := c.next()
+		if !ok {
+			return 0, false
+		}
+		if level == unix.SOL_SOCKET && typ == unix.SO_RXQ_OVFL && len(data) >= 4 {
+			return *(*uint32)(unsafe.Pointer(&data[0])), true
+		}
+	}
+}
+
+func familyName(family int) string {
+	if family == unix.AF_INET6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
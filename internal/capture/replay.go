@@ -0,0 +1,151 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// pcapngMagic is the block type of the section header block that starts a pcapng file
+const pcapngMagic = 0x0a0d0d0a
+
+// replayReader reads packets from a pcap or pcapng file
+type replayReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+}
+
+func openReplayReader(r io.Reader) (replayReader, func(gopacket.CaptureInfo) layers.LinkType, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+	if binary.LittleEndian.Uint32(magic) == pcapngMagic {
+		ng, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+		// pcapng files may mix interfaces with different link types
+		linkType := func(ci gopacket.CaptureInfo) layers.LinkType {
+			if intf, err := ng.Interface(ci.InterfaceIndex); err == nil {
+				return intf.LinkType
+			}
+			return ng.LinkType()
+		}
+		return ng, linkType, nil
+	}
+	pr, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pr, func(gopacket.CaptureInfo) layers.LinkType { return pr.LinkType() }, nil
+}
+
+// replayFile feeds the packets of the configured pcap or pcapng file through the
+// same decode path as live capture. Packets are timestamped with the hub clock
+// when they are replayed, so looped replays keep moving forward in time.
+func (ci *CaptureInstance) replayFile() error {
+	if ci.config.ReplaySpeed < 0 {
+		return fmt.Errorf("invalid replay speed: %v", ci.config.ReplaySpeed)
+	}
+	ci.logger.Info("started packet replay",
+		"file", ci.config.ReplayFile,
+		"speed", ci.config.ReplaySpeed,
+		"loop", ci.config.ReplayLoop)
+	for {
+		packets, err := ci.replayOnce()
+		if err != nil {
+			ci.logger.Error("Failed to replay file", "file", ci.config.ReplayFile, "error", err)
+			return err
+		}
+		ci.logger.Info("finished packet replay", "file", ci.config.ReplayFile, "packets", packets)
+		if !ci.config.ReplayLoop || ci.shutdown {
+			return nil
+		}
+		if packets == 0 {
+			return fmt.Errorf("replay file %s contains no packets", ci.config.ReplayFile)
+		}
+	}
+}
+
+// replayOnce replays the file from start to end and returns the number of packets read
+func (ci *CaptureInstance) replayOnce() (int, error) {
+	f, err := os.Open(ci.config.ReplayFile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r, linkType, err := openReplayReader(f)
+	if err != nil {
+		return 0, err
+	}
+
+	var fileStart, wallStart time.Time
+	packets := 0
+	for !ci.shutdown {
+		data, info, err := r.ReadPacketData()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return packets, err
+		}
+		packets++
+
+		// Pace the replay by the capture timestamps in the file
+		if ci.config.ReplaySpeed > 0 {
+			if packets == 1 {
+				fileStart, wallStart = info.Timestamp, time.Now()
+			}
+			offset := time.Duration(float64(info.Timestamp.Sub(fileStart)) / ci.config.ReplaySpeed)
+			if d := time.Until(wallStart.Add(offset)); d > 0 {
+				time.Sleep(d)
+			}
+		}
+
+		if err := ci.replayPacket(gopacket.NewPacket(data, linkType(info), gopacket.Default)); err != nil {
+			ci.logger.Warn("packet processing error", "socket", "replay", "error", err)
+		}
+	}
+	return packets, nil
+}
+
+// replayPacket decapsulates one packet read from the replay file. Only GRE and
+// datagrams to the configured UDP encapsulation ports are processed.
+func (ci *CaptureInstance) replayPacket(packet gopacket.Packet) error {
+	var src netip.Addr
+	timestamp, tsSource := time.Now(), internal.TimestampSourceHub
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		src, _ = netip.AddrFromSlice(ip.SrcIP.To4())
+		if ip.Protocol == layers.IPProtocolGRE {
+			return ci.processGRE(src, ip.Payload, timestamp, tsSource)
+		}
+	case *layers.IPv6:
+		src, _ = netip.AddrFromSlice(ip.SrcIP)
+		if ip.NextHeader == layers.IPProtocolGRE {
+			return ci.processGRE(src, ip.Payload, timestamp, tsSource)
+		}
+	default:
+		return nil
+	}
+	if udp, ok := packet.TransportLayer().(*layers.UDP); ok {
+		for _, encap := range ci.udpEncaps() {
+			if uint16(udp.DstPort) == encap.port {
+				return ci.processUDPPayload(encap.name, encap.decode, src, udp.Payload, timestamp, tsSource)
+			}
+		}
+	}
+	return nil
+}
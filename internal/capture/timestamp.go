@@ -0,0 +1,220 @@
+package capture
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"anthonyuk.dev/erspan-hub/internal"
+
+	"golang.org/x/sys/unix"
+)
+
+// kernelTimestamping is the socket option used to get kernel receive timestamps
+type kernelTimestamping int
+
+const (
+	timestampingNone     kernelTimestamping = iota
+	timestampingNS                          // SO_TIMESTAMPNS
+	timestampingFull                        // SO_TIMESTAMPING, software
+	timestampingHardware                    // SO_TIMESTAMPING, raw hardware and software
+)
+
+func (kt kernelTimestamping) String() string {
+	switch kt {
+	case timestampingNS:
+		return "SO_TIMESTAMPNS"
+	case timestampingFull:
+		return "SO_TIMESTAMPING"
+	case timestampingHardware:
+		return "SO_TIMESTAMPING raw hardware"
+	}
+	return "none"
+}
+
+// oob buffer size, large enough for one SCM_TIMESTAMPING message (three timespecs)
+var timestampOobLen = unix.CmsgSpace(3 * int(unsafe.Sizeof(unix.Timespec{})))
+
+// enableKernelTimestamps asks the kernel to attach a receive timestamp to every packet
+// read from fd, preferring SO_TIMESTAMPING and falling back to SO_TIMESTAMPNS. With hardware
+// the raw NIC timestamps are requested as well. The hub does not enable hardware timestamping
+// on the interface (SIOCSHWTSTAMP), that is left to e.g. ptp4l or hwstamp_ctl.
+func enableKernelTimestamps(fd int, hardware bool) (kernelTimestamping, error) {
+	flags := unix.SOF_TIMESTAMPING_RX_SOFTWARE | unix.SOF_TIMESTAMPING_SOFTWARE
+	if hardware {
+		flags |= unix.SOF_TIMESTAMPING_RX_HARDWARE | unix.SOF_TIMESTAMPING_RAW_HARDWARE
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPING, flags); err == nil {
+			return timestampingHardware, nil
+		}
+		flags &^= unix.SOF_TIMESTAMPING_RX_HARDWARE | unix.SOF_TIMESTAMPING_RAW_HARDWARE
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPING, flags); err == nil {
+		return timestampingFull, nil
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1); err != nil {
+		return timestampingNone, fmt.Errorf("failed to enable kernel timestamps: %w", err)
+	}
+	return timestampingNS, nil
+}
+
+// cmsgs iterates over the control messages in an oob buffer without allocating,
+// unlike unix.ParseSocketControlMessage
+type cmsgs []byte
+
+func (c *cmsgs) next() (level, typ int32, data []byte, ok bool) {
+	b := *c
+	if len(b) < unix.SizeofCmsghdr {
+		return 0, 0, nil, false
+	}
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	hdrLen := unix.CmsgLen(0)
+	if int(h.Len) < hdrLen || int(h.Len) > len(b) {
+		return 0, 0, nil, false
+	}
+	data = b[hdrLen:h.Len]
+	*c = b[min(unix.CmsgSpace(len(data)), len(b)):]
+	return h.Level, h.Type, data, true
+}
+
+// kernelTimestamp extracts the receive timestamp and its source from the control messages
+// of a packet. The raw hardware timestamp is only used, in preference to the software one,
+// if hardware is set, as the NIC clock is not necessarily the system clock.
+func kernelTimestamp(oob []byte, hardware bool) (time.Time, internal.TimestampSource, bool) {
+	tsLen := int(unsafe.Sizeof(unix.Timespec{}))
+	c := cmsgs(oob)
+	for {
+		level, typ, data, ok := c.next()
+		if !ok {
+			return time.Time{}, "", false
+		}
+		if level != unix.SOL_SOCKET {
+			continue
+		}
+		switch typ {
+		case unix.SCM_TIMESTAMPNS:
+			if len(data) >= tsLen {
+				ts := (*unix.Timespec)(unsafe.Pointer(&data[0]))
+				return time.Unix(ts.Unix()), internal.TimestampSourceKernel, true
+			}
+		case unix.SCM_TIMESTAMPING:
+			// struct scm_timestamping { struct timespec ts[3]; }, ts[0] is software, ts[2] is raw hardware
+			if len(data) >= 3*tsLen {
+				ts := (*[3]unix.Timespec)(unsafe.Pointer(&data[0]))
+				if hardware && (ts[2].Sec != 0 || ts[2].Nsec != 0) {
+					return time.Unix(ts[2].Unix()), internal.TimestampSourceHardware, true
+				}
+				if ts[0].Sec != 0 || ts[0].Nsec != 0 {
+					return time.Unix(ts[0].Unix()), internal.TimestampSourceKernel, true
+				}
+			}
+		}
+	}
+}
+
+// receiveTime returns the kernel receive timestamp from the control messages of a
+// packet if there is one, otherwise the hub clock
+func receiveTime(ts kernelTimestamping, oob []byte) (time.Time, internal.TimestampSource) {
+	if ts != timestampingNone {
+		if t, source, ok := kernelTimestamp(oob, ts == timestampingHardware); ok {
+			return t, source
+		}
+	}
+	return time.Now(), internal.TimestampSourceHub
+}
+
+// erspanClockMaxDrift is how far a hardware timestamp may stray from the receive
+// time before the receive time is used instead, re-anchoring a tick counter
+const erspanClockMaxDrift = time.Second
+
+// erspanClockMaxAge is how long the anchor of a stream without packets is kept.
+// A stream seen again after that is anchored anew, as the drift check would do.
+const erspanClockMaxAge = time.Minute
+
+// taiOffset is TAI - UTC, IEEE 1588 seconds count TAI. It changes with leap seconds,
+// the last one was at the end of 2016.
+const taiOffset = 37 * time.Second
+
+// erspanClock converts ERSPAN Type III hardware timestamps into wall clock time.
+// IEEE 1588 timestamps are absolute; 100us and 100ns timestamps are free-running
+// tick counters, which are anchored to the receive time of a stream's first packet.
+type erspanClock struct {
+	mu        sync.Mutex
+	anchors   map[internal.StreamKey]*erspanClockAnchor
+	lastPrune time.Time
+}
+
+type erspanClockAnchor struct {
+	ticks uint32
+	time  time.Time
+}
+
+func newErspanClock() *erspanClock {
+	return &erspanClock{
+		anchors: make(map[internal.StreamKey]*erspanClockAnchor),
+	}
+}
+
+// Time returns the hardware timestamp of the packet, or false if the header does
+// not carry a timestamp the hub can interpret
+func (c *erspanClock) Time(key internal.StreamKey, e *ERSPANIII, rx time.Time) (time.Time, bool) {
+	switch e.Granularity {
+	case ERSPANIIIGranularityIEEE1588:
+		if e.Timestamp >= uint32(time.Second) {
+			return time.Time{}, false
+		}
+		if sec, ok := e.TimestampSeconds(); ok {
+			// Some platforms send UTC rather than TAI seconds, take whichever is near rx.
+			// A timestamp far from both is not trusted, the packet keeps its receive time.
+			t := time.Unix(int64(sec), int64(e.Timestamp))
+			for _, t := range []time.Time{t.Add(-taiOffset), t} {
+				if d := t.Sub(rx); d <= erspanClockMaxDrift && d >= -erspanClockMaxDrift {
+					return t, true
+				}
+			}
+			return time.Time{}, false
+		}
+		// Only the nanoseconds are known, take the seconds from the receive time
+		t := time.Unix(rx.Unix(), int64(e.Timestamp))
+		if d := t.Sub(rx); d > time.Second/2 {
+			t = t.Add(-time.Second)
+		} else if d < -time.Second/2 {
+			t = t.Add(time.Second)
+		}
+		return t, true
+	case ERSPANIIIGranularity100us, ERSPANIIIGranularity100ns:
+		tick := e.Granularity.TickDuration()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.prune(rx)
+		a, ok := c.anchors[key]
+		if !ok {
+			a = &erspanClockAnchor{ticks: e.Timestamp, time: rx}
+			c.anchors[key] = a
+			return rx, true
+		}
+		// signed difference so that wraparound and reordered packets are handled
+		t := a.time.Add(time.Duration(int32(e.Timestamp-a.ticks)) * tick)
+		if d := t.Sub(rx); d > erspanClockMaxDrift || d < -erspanClockMaxDrift {
+			t = rx
+		}
+		a.ticks = e.Timestamp
+		a.time = t
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// prune removes the anchors of streams without packets for erspanClockMaxAge.
+// c.mu must be held.
+func (c *erspanClock) prune(now time.Time) {
+	if now.Sub(c.lastPrune) < erspanClockMaxAge {
+		return
+	}
+	c.lastPrune = now
+	for key, a := range c.anchors {
+		if now.Sub(a.time) > erspanClockMaxAge {
+			delete(c.anchors, key)
+		}
+	}
+}
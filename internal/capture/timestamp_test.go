@@ -0,0 +1,148 @@
+package capture
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+
+	"anthonyuk.dev/erspan-hub/internal"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestERSPANIIIGranularity(t *testing.T) {
+	tests := []struct {
+		g    ERSPANIIIGranularity
+		tick time.Duration
+		name string
+	}{
+		{ERSPANIIIGranularity100us, 100 * time.Microsecond, "100us"},
+		{ERSPANIIIGranularity100ns, 100 * time.Nanosecond, "100ns"},
+		{ERSPANIIIGranularityIEEE1588, time.Nanosecond, "IEEE 1588"},
+		{ERSPANIIIGranularityUser, 0, "user defined"},
+	}
+	for _, tt := range tests {
+		if tick := tt.g.TickDuration(); tick != tt.tick {
+			t.Errorf("%d: tick %s, want %s", tt.g, tick, tt.tick)
+		}
+		if name := tt.g.String(); name != tt.name {
+			t.Errorf("%d: name %q, want %q", tt.g, name, tt.name)
+		}
+	}
+}
+
+func TestErspanClockTicks(t *testing.T) {
+	rx := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		first   uint32
+		next    uint32
+		elapsed time.Duration // receive time of the second packet after the first
+		want    time.Duration // time of the second packet after the first
+	}{
+		{"forward", 1000, 1100, 10 * time.Millisecond, 10 * time.Millisecond},
+		{"jitter", 1000, 1100, 12 * time.Millisecond, 10 * time.Millisecond},
+		{"wraparound", 0xffffff00, 0x00000100, 51 * time.Millisecond, 512 * 100 * time.Microsecond},
+		{"reordered", 1000, 900, 0, -10 * time.Millisecond},
+		{"drift", 1000, 1000 + 20000, 100 * time.Millisecond, 100 * time.Millisecond}, // 2s of ticks, clamped to rx
+		{"reset", 1000, 5, 5 * time.Second, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		c := newErspanClock()
+		e := &ERSPANIII{Granularity: ERSPANIIIGranularity100us, Timestamp: tt.first}
+		if got, ok := c.Time(testKey(), e, rx); !ok || !got.Equal(rx) {
+			t.Fatalf("%s: first packet at %s, %v, want the receive time", tt.name, got, ok)
+		}
+		e.Timestamp = tt.next
+		got, ok := c.Time(testKey(), e, rx.Add(tt.elapsed))
+		if !ok || got.Sub(rx) != tt.want {
+			t.Errorf("%s: second packet %s after the first, want %s", tt.name, got.Sub(rx), tt.want)
+		}
+	}
+}
+
+func TestErspanClockIEEE1588(t *testing.T) {
+	rx := time.Unix(1700000000, 999_000_000)
+	tests := []struct {
+		name    string
+		ns      uint32
+		seconds int64 // from the subheader, 0 for none
+		want    time.Time
+		ok      bool
+	}{
+		{"same second", 998_000_000, 0, time.Unix(1700000000, 998_000_000), true},
+		{"next second", 1_000_000, 0, time.Unix(1700000001, 1_000_000), true},
+		{"invalid nanoseconds", 1_000_000_000, 0, time.Time{}, false},
+		{"TAI seconds", 998_000_000, 1700000037, time.Unix(1700000000, 998_000_000), true},
+		{"UTC seconds", 998_000_000, 1700000000, time.Unix(1700000000, 998_000_000), true},
+		{"wrong seconds", 998_000_000, 1600000000, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		e := &ERSPANIII{Granularity: ERSPANIIIGranularityIEEE1588, Timestamp: tt.ns}
+		if tt.seconds != 0 {
+			e.HasSubHeader, e.PlatformID, e.PlatformInfo = true, 0x03, uint64(tt.seconds)
+		}
+		got, ok := newErspanClock().Time(testKey(), e, rx)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("%s: %s, %v, want %s, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestErspanClockUserGranularity(t *testing.T) {
+	e := &ERSPANIII{Granularity: ERSPANIIIGranularityUser, Timestamp: 1}
+	if _, ok := newErspanClock().Time(testKey(), e, time.Now()); ok {
+		t.Error("user defined granularity gave a time")
+	}
+}
+
+func TestErspanClockPrune(t *testing.T) {
+	c := newErspanClock()
+	rx := time.Unix(1700000000, 0)
+	old, active := testKey(), testKey()
+	active.ErspanID++
+	e := &ERSPANIII{Granularity: ERSPANIIIGranularity100ns, Timestamp: 1}
+	c.Time(old, e, rx)
+	c.Time(active, e, rx)
+	for i := 1; i <= 4; i++ {
+		c.Time(active, e, rx.Add(time.Duration(i)*erspanClockMaxAge/2))
+	}
+	if _, ok := c.anchors[old]; ok {
+		t.Error("anchor of a stream without packets was kept")
+	}
+	if _, ok := c.anchors[active]; !ok {
+		t.Error("anchor of an active stream was removed")
+	}
+}
+
+func TestKernelTimestamp(t *testing.T) {
+	tsLen := int(unsafe.Sizeof(unix.Timespec{}))
+	oob := make([]byte, unix.CmsgSpace(3*tsLen))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level, h.Type = unix.SOL_SOCKET, unix.SCM_TIMESTAMPING
+	h.SetLen(unix.CmsgLen(3 * tsLen))
+	ts := (*[3]unix.Timespec)(unsafe.Pointer(&oob[unix.CmsgLen(0)]))
+	ts[0].Sec, ts[2].Sec = 100, 137
+
+	tests := []struct {
+		hardware bool
+		sec      int64
+		source   internal.TimestampSource
+	}{
+		{false, 100, internal.TimestampSourceKernel},
+		{true, 137, internal.TimestampSourceHardware},
+	}
+	for _, tt := range tests {
+		got, source, ok := kernelTimestamp(oob, tt.hardware)
+		if !ok || got.Unix() != tt.sec || source != tt.source {
+			t.Errorf("hardware %v: %d %s, want %d %s", tt.hardware, got.Unix(), source, tt.sec, tt.source)
+		}
+	}
+	if _, _, ok := kernelTimestamp(oob[:unix.CmsgLen(0)], false); ok {
+		t.Error("truncated control message gave a time")
+	}
+}
+
+func testKey() internal.StreamKey {
+	return internal.StreamKey{SrcIP: testSrc, ErspanID: 42}
+}
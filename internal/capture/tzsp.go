@@ -0,0 +1,75 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+// TZSP (TaZmen Sniffer Protocol) as sent by MikroTik and other exporters
+const (
+	tzspVersion = 1
+
+	tzspTypeReceivedTagList   = 0
+	tzspTypePacketForTransmit = 1
+	tzspTypeKeepalive         = 4
+
+	tzspEncapEthernet = 1
+
+	tzspTagPadding  = 0
+	tzspTagEnd      = 1
+	tzspTagSensorID = 60 // sensor MAC address
+)
+
+// decodeTZSP decapsulates TZSP traffic. Streams are keyed by source IP and, if the
+// exporter sends one, the last four bytes of the sensor ID tag.
+func decodeTZSP(src netip.Addr, data []byte) (internal.StreamKey, []byte, error) {
+	key := internal.StreamKey{SrcIP: src, Encap: internal.EncapTZSP}
+	if len(data) < 4 {
+		return key, nil, fmt.Errorf("TZSP header too short: %d bytes", len(data))
+	}
+	if data[0] != tzspVersion {
+		return key, nil, fmt.Errorf("unsupported TZSP version %d", data[0])
+	}
+	switch data[1] {
+	case tzspTypeReceivedTagList, tzspTypePacketForTransmit:
+	case tzspTypeKeepalive:
+		return key, nil, nil
+	default:
+		return key, nil, fmt.Errorf("unsupported TZSP type %d", data[1])
+	}
+	if encap := binary.BigEndian.Uint16(data[2:4]); encap != tzspEncapEthernet {
+		return key, nil, fmt.Errorf("unsupported TZSP encapsulation %d", encap)
+	}
+
+	offset := 4
+	for {
+		if offset >= len(data) {
+			return key, nil, fmt.Errorf("TZSP tagged fields not terminated")
+		}
+		tag := data[offset]
+		offset++
+		if tag == tzspTagEnd {
+			break
+		}
+		if tag == tzspTagPadding {
+			continue
+		}
+		if offset >= len(data) {
+			return key, nil, fmt.Errorf("TZSP tag %d truncated", tag)
+		}
+		length := int(data[offset])
+		offset++
+		if offset+length > len(data) {
+			return key, nil, fmt.Errorf("TZSP tag %d truncated", tag)
+		}
+		value := data[offset : offset+length]
+		offset += length
+		if tag == tzspTagSensorID && length >= 4 {
+			key.ErspanID = binary.BigEndian.Uint32(value[length-4:])
+		}
+	}
+	return key, data[offset:], nil
+}
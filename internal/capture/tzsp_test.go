@@ -0,0 +1,78 @@
+package capture
+
+import (
+	"bytes"
+	"testing"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+// tzspPacket builds a TZSP header of a packet type with tagged fields, followed by payload.
+// The tags are given as raw bytes and must include the end tag.
+func tzspPacket(typ byte, tags []byte, payload []byte) []byte {
+	h := append([]byte{tzspVersion, typ, 0, tzspEncapEthernet}, tags...)
+	return append(h, payload...)
+}
+
+func TestDecodeTZSP(t *testing.T) {
+	mac := []byte{tzspTagSensorID, 6, 0x00, 0x0c, 0x42, 0x01, 0x02, 0x03}
+	tests := []struct {
+		name string
+		typ  byte
+		tags []byte
+		id   uint32
+	}{
+		{"end only", tzspTypeReceivedTagList, []byte{tzspTagEnd}, 0},
+		{"for transmit", tzspTypePacketForTransmit, []byte{tzspTagEnd}, 0},
+		{"padding", tzspTypeReceivedTagList, []byte{tzspTagPadding, tzspTagPadding, tzspTagEnd}, 0},
+		{"sensor MAC", tzspTypeReceivedTagList, append(mac, tzspTagEnd), 0x42010203},
+		{"other tags", tzspTypeReceivedTagList, append([]byte{10, 1, 0xc4, tzspTagPadding, 12, 0}, append(mac, tzspTagEnd)...), 0x42010203},
+		{"short sensor ID", tzspTypeReceivedTagList, []byte{tzspTagSensorID, 2, 0x12, 0x34, tzspTagEnd}, 0},
+		{"four byte sensor ID", tzspTypeReceivedTagList, []byte{tzspTagSensorID, 4, 0xde, 0xad, 0xbe, 0xef, tzspTagEnd}, 0xdeadbeef},
+	}
+	for _, tt := range tests {
+		key, frame, err := decodeTZSP(testSrc, tzspPacket(tt.typ, tt.tags, testFrame))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		want := internal.StreamKey{SrcIP: testSrc, ErspanID: tt.id, Encap: internal.EncapTZSP}
+		if key != want || !bytes.Equal(frame, testFrame) {
+			t.Errorf("%s: %v with %d bytes, want %v with %d", tt.name, key, len(frame), want, len(testFrame))
+		}
+	}
+}
+
+func TestDecodeTZSPKeepalive(t *testing.T) {
+	key, frame, err := decodeTZSP(testSrc, []byte{tzspVersion, tzspTypeKeepalive, 0, tzspEncapEthernet})
+	if err != nil || frame != nil || key.SrcIP != testSrc {
+		t.Errorf("keepalive: %v, %d bytes, %v", key, len(frame), err)
+	}
+}
+
+func TestDecodeTZSPInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"version 2", []byte{2, tzspTypeReceivedTagList, 0, tzspEncapEthernet, tzspTagEnd}},
+		{"type 3", []byte{tzspVersion, 3, 0, tzspEncapEthernet, tzspTagEnd}},
+		{"802.11", []byte{tzspVersion, tzspTypeReceivedTagList, 0, 18, tzspTagEnd}},
+		{"not terminated", tzspPacket(tzspTypeReceivedTagList, []byte{tzspTagPadding, 10, 1, 0xc4}, nil)},
+		{"tag without length", tzspPacket(tzspTypeReceivedTagList, []byte{10}, nil)},
+		{"tag value truncated", tzspPacket(tzspTypeReceivedTagList, []byte{tzspTagSensorID, 6, 0x00, 0x0c}, nil)},
+	}
+	for _, tt := range tests {
+		if _, _, err := decodeTZSP(testSrc, tt.data); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+
+	// Every truncation of the header must fail
+	data := tzspPacket(tzspTypeReceivedTagList, []byte{tzspTagPadding, tzspTagSensorID, 6, 0x00, 0x0c, 0x42, 0x01, 0x02, 0x03, tzspTagEnd}, nil)
+	for n := 0; n < len(data); n++ {
+		if _, _, err := decodeTZSP(testSrc, data[:n]); err == nil {
+			t.Errorf("%d of %d header bytes: no error", n, len(data))
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package capture
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+// udpDecoder decapsulates a UDP payload from src, returning the stream key and the
+// mirrored Ethernet frame. A nil frame without an error means there is nothing to
+// forward, e.g. a keepalive.
+type udpDecoder func(src netip.Addr, data []byte) (internal.StreamKey, []byte, error)
+
+// udpEncap is a UDP based mirror encapsulation enabled in the config
+type udpEncap struct {
+	name   string
+	port   uint16
+	decode udpDecoder
+}
+
+// udpEncaps returns the UDP encapsulations that have a port configured
+func (ci *CaptureInstance) udpEncaps() []udpEncap {
+	var encaps []udpEncap
+	if ci.config.VXLANPort != 0 {
+		encaps = append(encaps, udpEncap{name: "VXLAN", port: ci.config.VXLANPort, decode: decodeVXLAN})
+	}
+	if ci.config.TZSPPort != 0 {
+		encaps = append(encaps, udpEncap{name: "TZSP", port: ci.config.TZSPPort, decode: decodeTZSP})
+	}
+	return encaps
+}
+
+// udpListener receives mirror traffic that is encapsulated in UDP rather than GRE
+type udpListener struct {
+	name         string
+	conn         *net.UDPConn
+	buf          []byte
+	oob          []byte
+	timestamping kernelTimestamping
+	decode       udpDecoder
+}
+
+func (ci *CaptureInstance) openUDPListener(name string, port uint16, decode udpDecoder) (*udpListener, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(port)})
+	if err != nil {
+		return nil, err
+	}
+	l := &udpListener{
+		name:   name,
+		conn:   conn,
+		buf:    make([]byte, 65535),
+		decode: decode,
+	}
+	rc, err := conn.SyscallConn()
+	if err == nil {
+		cerr := rc.Control(func(fd uintptr) {
+			l.timestamping, err = ci.setupTimestamps(int(fd), name)
+		})
+		if cerr != nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if l.timestamping != timestampingNone {
+		l.oob = make([]byte, timestampOobLen)
+	}
+	ci.logger.Info("started packet capture", "protocol", name, "addr", conn.LocalAddr().String())
+	return l, nil
+}
+
+// processUDPPacket handles one UDP datagram and forwards the decapsulated frame to matching sessions
+func (ci *CaptureInstance) processUDPPacket(l *udpListener) error {
+	n, oobn, _, from, err := l.conn.ReadMsgUDPAddrPort(l.buf, l.oob)
+	if err != nil {
+		return err
+	}
+	timestamp, tsSource := receiveTime(l.timestamping, l.oob[:oobn])
+	return ci.processUDPPayload(l.name, l.decode, from.Addr().Unmap(), l.buf[:n], timestamp, tsSource)
+}
+
+// processUDPPayload decapsulates the payload of a UDP datagram from src and forwards
+// it to matching sessions. It is shared by the UDP listeners and file replay.
+func (ci *CaptureInstance) processUDPPayload(name string, decode udpDecoder, src netip.Addr, data []byte, timestamp time.Time, tsSource internal.TimestampSource) error {
+	key, inner, err := decode(src, data)
+	if err != nil {
+		return fmt.Errorf("bad %s packet from %s: %w", name, src, err)
+	}
+	if inner == nil {
+		return nil
+	}
+	ci.forwardPacket(&internal.PacketInfo{
+		Key:             key,
+		Timestamp:       timestamp,
+		TimestampSource: tsSource,
+	}, inner)
+	return nil
+}
@@ -0,0 +1,24 @@
+package capture
+
+import (
+	"fmt"
+	"net/netip"
+
+	"anthonyuk.dev/erspan-hub/internal"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// decodeVXLAN decapsulates VXLAN mirror traffic (AWS VPC Traffic Mirroring, Azure vTAP).
+// Streams are keyed by source IP and VNI.
+func decodeVXLAN(src netip.Addr, data []byte) (internal.StreamKey, []byte, error) {
+	var vxlan layers.VXLAN
+	if err := vxlan.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		return internal.StreamKey{}, nil, err
+	}
+	if !vxlan.ValidIDFlag {
+		return internal.StreamKey{}, nil, fmt.Errorf("VXLAN header without a valid VNI")
+	}
+	return internal.StreamKey{SrcIP: src, ErspanID: vxlan.VNI, Encap: internal.EncapVXLAN}, vxlan.Payload, nil
+}
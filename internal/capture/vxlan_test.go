@@ -0,0 +1,44 @@
+package capture
+
+import (
+	"bytes"
+	"testing"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+const vxlanFlagValidVNI = 0x08
+
+// vxlanPacket builds a VXLAN header followed by payload
+func vxlanPacket(flags byte, vni uint32, payload []byte) []byte {
+	h := []byte{flags, 0, 0, 0, byte(vni >> 16), byte(vni >> 8), byte(vni), 0}
+	return append(h, payload...)
+}
+
+func TestDecodeVXLAN(t *testing.T) {
+	for _, vni := range []uint32{0, 42, 0xffffff} {
+		key, frame, err := decodeVXLAN(testSrc, vxlanPacket(vxlanFlagValidVNI, vni, testFrame))
+		if err != nil {
+			t.Fatalf("VNI %d: %v", vni, err)
+		}
+		want := internal.StreamKey{SrcIP: testSrc, ErspanID: vni, Encap: internal.EncapVXLAN}
+		if key != want || !bytes.Equal(frame, testFrame) {
+			t.Errorf("VNI %d: %v with %d bytes, want %v with %d", vni, key, len(frame), want, len(testFrame))
+		}
+	}
+	if key, _, err := decodeVXLAN(testSrc6, vxlanPacket(vxlanFlagValidVNI, 7, testFrame)); err != nil || key.SrcIP != testSrc6 {
+		t.Errorf("IPv6 source: %v, %v", key, err)
+	}
+}
+
+func TestDecodeVXLANInvalid(t *testing.T) {
+	if _, _, err := decodeVXLAN(testSrc, vxlanPacket(0, 42, testFrame)); err == nil {
+		t.Error("no error without the I flag")
+	}
+	data := vxlanPacket(vxlanFlagValidVNI, 42, nil)
+	for n := 0; n < len(data); n++ {
+		if _, _, err := decodeVXLAN(testSrc, data[:n]); err == nil {
+			t.Errorf("%d of %d header bytes: no error", n, len(data))
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package capture
+
+import (
+	"sync"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+// packets a receive loop can queue for a worker before it blocks
+const workerQueueLength = 1024
+
+// packetJob is a decapsulated packet queued for a capture worker
+type packetJob struct {
+	pi internal.PacketInfo
+	pb *internal.PacketBuffer
+}
+
+// workerPool runs the stream accounting and forwarding of packets on several
+// goroutines. Packets are assigned to a worker by a hash of their stream key, so
+// the packets of one stream stay in order while different streams run in parallel.
+type workerPool struct {
+	queues []chan packetJob
+	wg     sync.WaitGroup
+}
+
+func (ci *CaptureInstance) startWorkers(n int) *workerPool {
+	wp := &workerPool{queues: make([]chan packetJob, n)}
+	for i := range wp.queues {
+		q := make(chan packetJob, workerQueueLength)
+		wp.queues[i] = q
+		wp.wg.Add(1)
+		go func() {
+			defer wp.wg.Done()
+			for job := range q {
+				ci.fsmgr.ProcessPacket(&job.pi, job.pb)
+			}
+		}()
+	}
+	ci.logger.Info("started capture workers", "workers", n)
+	return wp
+}
+
+// dispatch queues a packet for the worker that owns its stream
+func (wp *workerPool) dispatch(pi *internal.PacketInfo, pb *internal.PacketBuffer) {
+	q := wp.queues[pi.Key.Hash()%uint64(len(wp.queues))]
+	q <- packetJob{pi: *pi, pb: pb}
+}
+
+// stop waits for the workers to finish the queued packets
+func (wp *workerPool) stop() {
+	for _, q := range wp.queues {
+		close(q)
+	}
+	wp.wg.Wait()
+}
@@ -86,8 +86,9 @@ func (c *Client) Close() {
 	c.Conn = nil
 }
 
-func (c *Client) ListStreams(ctx context.Context) (streams []*StreamInfo, err error) {
-	resp, err := c.StreamsClient.ListStreams(ctx, &streams_v1.ListStreamsRequest{})
+// ListStreams lists the streams of the hub, or those matching a query if it is not empty
+func (c *Client) ListStreams(ctx context.Context, query string) (streams []*StreamInfo, err error) {
+	resp, err := c.StreamsClient.ListStreams(ctx, &streams_v1.ListStreamsRequest{Query: query})
 	if err != nil {
 		c.Logger.Error("could not list streams", "error", err)
 		return nil, err
@@ -97,23 +98,34 @@ func (c *Client) ListStreams(ctx context.Context) (streams []*StreamInfo, err er
 	for _, stream := range resp.Streams {
 		sinfo := StreamInfo{
 			ID:              stream.Id,
-			SrcIP:           IPFromUint32(stream.SrcIp),
-			ErspanID:        uint16(stream.ErspanId),
+			SrcIP:           IPFromAddrOrUint32(stream.SrcAddr, stream.SrcIp),
+			ErspanID:        stream.ErspanId,
+			Encap:           stream.Encap,
 			ErspanVersion:   uint8(stream.ErspanVersion),
 			FirstSeen:       time.Unix(0, stream.FirstSeen),
 			LastSeen:        time.Unix(0, stream.LastSeen),
 			Packets:         stream.Packets,
 			Bytes:           stream.Bytes,
+			SeqLost:         stream.SeqLost,
+			SeqDuplicate:    stream.SeqDuplicate,
+			SeqOutOfOrder:   stream.SeqOutOfOrder,
+			State:           stream.State,
+			Name:            stream.Name,
+			Site:            stream.Site,
+			Description:     stream.Description,
+			Labels:          stream.Labels,
 			ForwardSessions: make([]*ForwardSessionInfo, 0, len(stream.ForwardSessions)),
 		}
 		for _, session := range stream.ForwardSessions {
 			sinfo.ForwardSessions = append(sinfo.ForwardSessions, &ForwardSessionInfo{
-				SrcIP:        IPFromUint32(session.SrcIp),
-				ErspanID:     uint16(session.ErspanId),
-				StreamInfoID: sinfo.ID,
-				Type:         session.Type,
-				Filter:       session.Filter,
-				Info:         session.Info,
+				SrcIP:          IPFromAddrOrUint32(session.SrcAddr, session.SrcIp),
+				ErspanID:       session.ErspanId,
+				Encap:          session.Encap,
+				StreamInfoID:   sinfo.ID,
+				Type:           session.Type,
+				Filter:         session.Filter,
+				DroppedPackets: session.DroppedPackets,
+				Info:           session.Info,
 			})
 		}
 		streams = append(streams, &sinfo)
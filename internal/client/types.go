@@ -8,24 +8,44 @@ import (
 type StreamInfo struct {
 	ID              string                `json:"id"`
 	SrcIP           net.IP                `json:"src_ip"`
-	ErspanID        uint16                `json:"erspan_id"`
+	ErspanID        uint32                `json:"erspan_id"`
+	Encap           string                `json:"encap"`
 	ErspanVersion   uint8                 `json:"erspan_version"`
 	FirstSeen       time.Time             `json:"first_seen"`
 	LastSeen        time.Time             `json:"last_seen"`
 	Packets         uint64                `json:"packets"`
 	Bytes           uint64                `json:"bytes"`
+	SeqLost         uint64                `json:"seq_lost"`
+	SeqDuplicate    uint64                `json:"seq_duplicate"`
+	SeqOutOfOrder   uint64                `json:"seq_out_of_order"`
+	State           string                `json:"state"`
+	Name            string                `json:"name,omitempty"`
+	Site            string                `json:"site,omitempty"`
+	Description     string                `json:"description,omitempty"`
+	Labels          map[string]string     `json:"labels,omitempty"`
 	ForwardSessions []*ForwardSessionInfo `json:"forward_sessions"`
 }
 
 type ForwardSessionInfo struct {
-	SrcIP        net.IP            `json:"src_ip"`
-	ErspanID     uint16            `json:"erspan_id"`
-	StreamInfoID string            `json:"stream_info_id"`
-	Type         string            `json:"type"`
-	Filter       string            `json:"filter"`
-	Info         map[string]string `json:"info"`
+	SrcIP          net.IP            `json:"src_ip"`
+	ErspanID       uint32            `json:"erspan_id"`
+	Encap          string            `json:"encap"`
+	StreamInfoID   string            `json:"stream_info_id"`
+	Type           string            `json:"type"`
+	Filter         string            `json:"filter"`
+	DroppedPackets uint64            `json:"dropped_packets"`
+	Info           map[string]string `json:"info"`
 }
 
 func IPFromUint32(ip uint32) net.IP {
 	return net.IPv4(byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip))
 }
+
+// IPFromAddrOrUint32 uses the address bytes sent by servers with IPv6 support,
+// falling back to the IPv4-only uint32 field of older servers
+func IPFromAddrOrUint32(addr []byte, ip uint32) net.IP {
+	if len(addr) == net.IPv4len || len(addr) == net.IPv6len {
+		return net.IP(addr)
+	}
+	return IPFromUint32(ip)
+}
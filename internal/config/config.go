@@ -12,16 +12,44 @@ import (
 )
 
 type Config struct {
-	RestIP          string `koanf:"rest-ip"`
-	RestPort        uint16 `koanf:"rest-port"`
-	RestPrefix      string `koanf:"rest-prefix"`
-	GrpcIP          string `koanf:"grpc-ip"`
-	GrpcPort        uint16 `koanf:"grpc-port"`
-	GrpcTLSCertFile string `koanf:"grpc-tls-cert-file"`
-	GrpcTLSKeyFile  string `koanf:"grpc-tls-key-file"`
-	LogLevel        int    `koanf:"verbose"`
-	LogJson         bool   `koanf:"log-json"`
-	ShowVersion     bool   `koanf:"version"`
+	RestIP             string   `koanf:"rest-ip"`
+	RestPort           uint16   `koanf:"rest-port"`
+	RestPrefix         string   `koanf:"rest-prefix"`
+	GrpcIP             string   `koanf:"grpc-ip"`
+	GrpcPort           uint16   `koanf:"grpc-port"`
+	GrpcTLSCertFile    string   `koanf:"grpc-tls-cert-file"`
+	GrpcTLSKeyFile     string   `koanf:"grpc-tls-key-file"`
+	CaptureBackend     string   `koanf:"capture-backend"`
+	CaptureIface       string   `koanf:"capture-interface"`
+	RingBlockSize      uint32   `koanf:"ring-block-size"`
+	RingBlocks         uint32   `koanf:"ring-blocks"`
+	CaptureWorkers     int      `koanf:"capture-workers"`
+	CaptureIPv6        bool     `koanf:"ipv6"`
+	TypeISessionID     uint16   `koanf:"type1-session-id"`
+	TimestampSource    string   `koanf:"timestamp-source"`
+	VXLANPort          uint16   `koanf:"vxlan-port"`
+	TZSPPort           uint16   `koanf:"tzsp-port"`
+	ReplayFile         string   `koanf:"replay-file"`
+	ReplaySpeed        float64  `koanf:"replay-speed"`
+	ReplayLoop         bool     `koanf:"replay-loop"`
+	QueueLength        int      `koanf:"session-queue-length"`
+	DropPolicy         string   `koanf:"session-drop-policy"`
+	BlockTimeout       int      `koanf:"session-block-timeout"`
+	FilterSources      []string `koanf:"filter-source"`
+	FilterGREProtocols []string `koanf:"filter-gre-protocol"`
+	FilterSessionIDs   []string `koanf:"filter-session-id"`
+	AllowSources       []string `koanf:"allow-source"`
+	AllowSessionIDs    []string `koanf:"allow-session-id"`
+	MaxStreams         int      `koanf:"max-streams"`
+	IdleTimeout        int      `koanf:"stream-idle-timeout"`
+	ExpiryTimeout      int      `koanf:"stream-expiry-timeout"`
+	PendingTimeout     int      `koanf:"session-wait-timeout"`
+	InventoryFile      string   `koanf:"inventory-file"`
+	VirtualStreams     []string `koanf:"virtual-stream"`
+	MergeJitter        int      `koanf:"virtual-stream-jitter"`
+	LogLevel           int      `koanf:"verbose"`
+	LogJson            bool     `koanf:"log-json"`
+	ShowVersion        bool     `koanf:"version"`
 }
 
 func LoadConfig() (*Config, error) {
@@ -38,6 +66,34 @@ func LoadConfig() (*Config, error) {
 	fs.Uint16("grpc-port", 9090, "Port for gRPC server")
 	fs.String("grpc-tls-cert-file", "", "Path to gRPC TLS certificate file")
 	fs.String("grpc-tls-key-file", "", "Path to gRPC TLS key file")
+	fs.String("capture-backend", "socket", "GRE capture backend (socket, afpacket)")
+	fs.String("capture-interface", "", "Interface for the afpacket backend (default all interfaces)")
+	fs.Uint32("ring-block-size", 1<<20, "afpacket ring block size in bytes, a multiple of the page size")
+	fs.Uint32("ring-blocks", 64, "Number of blocks in the afpacket ring")
+	fs.Int("capture-workers", 1, "Number of packet forwarding workers, streams are spread across them")
+	fs.Bool("ipv6", false, "Also capture ERSPAN over IPv6")
+	fs.Uint16("type1-session-id", 0, "ERSPAN ID to assign to ERSPAN Type I streams")
+	fs.String("timestamp-source", "hub", "Packet timestamp source (hub, kernel, hardware, erspan); hardware takes raw NIC timestamps as UTC, hardware timestamping must be enabled on the interface and the NIC clock synchronised to UTC, e.g. by phc2sys")
+	fs.Uint16("vxlan-port", 0, "UDP port for VXLAN mirror traffic, e.g. 4789 (0 to disable)")
+	fs.Uint16("tzsp-port", 0, "UDP port for TZSP mirror traffic, e.g. 37008 (0 to disable)")
+	fs.String("replay-file", "", "Replay ERSPAN traffic from a pcap or pcapng file instead of capturing")
+	fs.Float64("replay-speed", 1, "Replay speed multiplier, 1 for realtime (0 for as fast as possible)")
+	fs.Bool("replay-loop", false, "Restart the replay at the end of the file")
+	fs.Int("session-queue-length", 1024, "Packets queued per forward session")
+	fs.String("session-drop-policy", "drop-newest", "What to do when a forward session queue is full (drop-newest, drop-oldest, block)")
+	fs.Int("session-block-timeout", 100, "Milliseconds the block drop policy waits for slow sessions per packet, shared by all sessions of the stream")
+	fs.StringSlice("filter-source", nil, "Only capture GRE from these exporter prefixes, filtered in the kernel (repeatable)")
+	fs.StringSlice("filter-gre-protocol", nil, "Only capture these GRE protocol types, e.g. 0x88be (repeatable)")
+	fs.StringSlice("filter-session-id", nil, "Only capture these ERSPAN IDs or ranges, e.g. 100-199 (repeatable)")
+	fs.StringSlice("allow-source", nil, "Only create streams for exporters in these prefixes (repeatable)")
+	fs.StringSlice("allow-session-id", nil, "Only create streams for these session IDs or ranges, e.g. 100-199 (repeatable)")
+	fs.Int("max-streams", 0, "Maximum number of captured streams, virtual streams are not counted (0 for no limit)")
+	fs.Int("stream-idle-timeout", 30, "Seconds without packets before a stream is shown as idle")
+	fs.Int("stream-expiry-timeout", 0, "Seconds without packets before a stream is removed, closing its forward sessions (0 to keep streams)")
+	fs.Int("session-wait-timeout", 300, "Seconds a forward session waits for a stream that has not appeared yet (0 to wait forever)")
+	fs.String("inventory-file", "", "JSON file naming and labelling streams, reloaded on SIGHUP")
+	fs.StringSlice("virtual-stream", nil, "Virtual stream merging streams in timestamp order, e.g. core=10.1.2.3/42+10.1.2.4/* (repeatable)")
+	fs.Int("virtual-stream-jitter", 50, "Milliseconds virtual streams hold packets to merge them in timestamp order")
 	fs.BoolP("log-json", "j", false, "Enable JSON formatted logs")
 	fs.CountP("verbose", "v", "Verbose logging (-v, -vv, -vvv)")
 	fs.BoolP("version", "V", false, "Show version information")
@@ -0,0 +1,117 @@
+package forward
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RejectReason says why a packet was not accepted into the stream registry
+type RejectReason string
+
+const (
+	RejectSource     RejectReason = "source"      // exporter not in the allowed prefixes
+	RejectSessionID  RejectReason = "session_id"  // session ID not in the allowed ranges
+	RejectMaxStreams RejectReason = "max_streams" // the registry is full
+)
+
+// sources tracked individually, beyond this rejections are counted under rejectOtherSource
+// so that spoofed traffic cannot grow the table without bound
+const (
+	maxRejectedSources = 4096
+	rejectOtherSource  = "other"
+)
+
+// RejectedSource counts the packets of an exporter that were rejected
+type RejectedSource struct {
+	SrcIP     string       `json:"src_ip"`
+	Reason    RejectReason `json:"reason"`
+	LastKey   StreamKey    `json:"last_key"`
+	Packets   uint64       `json:"packets"`
+	Bytes     uint64       `json:"bytes"`
+	FirstSeen time.Time    `json:"first_seen"`
+	LastSeen  time.Time    `json:"last_seen"`
+}
+
+type rejectedKey struct {
+	src    string
+	reason RejectReason
+}
+
+// accessControl decides which exporters may create streams and keeps track of the rejected ones
+type accessControl struct {
+	sources    []netip.Prefix
+	sessionIDs []IDRange
+	maxStreams int
+
+	mu       sync.Mutex
+	rejected map[rejectedKey]*RejectedSource
+	packets  *prometheus.CounterVec
+}
+
+func newAccessControl(cfg *Config) *accessControl {
+	return &accessControl{
+		sources:    cfg.AllowedSources,
+		sessionIDs: cfg.AllowedSessionIDs,
+		maxStreams: cfg.MaxStreams,
+		rejected:   make(map[rejectedKey]*RejectedSource),
+		packets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rejected_packets",
+			Help: "Packets not accepted into the stream registry per exporter",
+		}, []string{"src_ip", "reason"}),
+	}
+}
+
+// check returns why a stream may not be created, or "" if it may
+func (ac *accessControl) check(key StreamKey) RejectReason {
+	if len(ac.sources) > 0 && !slices.ContainsFunc(ac.sources, func(p netip.Prefix) bool { return p.Contains(key.SrcIP) }) {
+		return RejectSource
+	}
+	if len(ac.sessionIDs) > 0 && !slices.ContainsFunc(ac.sessionIDs, func(r IDRange) bool { return r.Contains(key.ErspanID) }) {
+		return RejectSessionID
+	}
+	return ""
+}
+
+// reject counts a packet that was not accepted
+func (ac *accessControl) reject(pi *PacketInfo, bytes int, reason RejectReason) {
+	src := pi.Key.SrcIP.String()
+	ac.mu.Lock()
+	rk := rejectedKey{src: src, reason: reason}
+	rs, ok := ac.rejected[rk]
+	if !ok {
+		if len(ac.rejected) >= maxRejectedSources {
+			rk.src = rejectOtherSource
+			rs, ok = ac.rejected[rk]
+		}
+		if !ok {
+			rs = &RejectedSource{SrcIP: rk.src, Reason: reason, FirstSeen: pi.Timestamp}
+			ac.rejected[rk] = rs
+		}
+	}
+	rs.LastKey = pi.Key
+	rs.Packets++
+	rs.Bytes += uint64(bytes)
+	rs.LastSeen = pi.Timestamp
+	ac.mu.Unlock()
+	ac.packets.WithLabelValues(rk.src, string(reason)).Inc()
+}
+
+// RejectedSources returns the exporters whose traffic was rejected, most packets first
+func (fsm *ForwardSessionManager) RejectedSources() []RejectedSource {
+	ac := fsm.access
+	ac.mu.Lock()
+	list := make([]RejectedSource, 0, len(ac.rejected))
+	for _, rs := range ac.rejected {
+		list = append(list, *rs)
+	}
+	ac.mu.Unlock()
+	slices.SortFunc(list, func(a, b RejectedSource) int {
+		return cmp.Compare(b.Packets, a.Packets)
+	})
+	return list
+}
@@ -0,0 +1,44 @@
+package forward
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+// DropPolicy decides what happens to a packet when a session's queue is full
+type DropPolicy string
+
+const (
+	DropNewest DropPolicy = "drop-newest" // discard the packet being forwarded
+	DropOldest DropPolicy = "drop-oldest" // discard the oldest queued packet to make room
+	DropBlock  DropPolicy = "block"       // wait up to BlockTimeout per packet for room, then discard the packet
+)
+
+func ParseDropPolicy(s string) (DropPolicy, error) {
+	switch p := DropPolicy(s); p {
+	case DropNewest, DropOldest, DropBlock:
+		return p, nil
+	case "":
+		return DropNewest, nil
+	}
+	return "", fmt.Errorf("unknown drop policy: %s", s)
+}
+
+type Config struct {
+	QueueLength  int           // packets queued per forward session
+	DropPolicy   DropPolicy    // what to do when a session's queue is full
+	BlockTimeout time.Duration // how long the block policy waits for slow sessions per packet, in total
+	// Streams are only created for allowed exporters and session IDs, an empty list allows all
+	AllowedSources    []netip.Prefix
+	AllowedSessionIDs []internal.IDRange
+	MaxStreams        int           // captured streams, virtual streams are not counted, 0 for no limit
+	IdleTimeout       time.Duration // a stream without packets for this long is idle
+	ExpiryTimeout     time.Duration // a stream without packets for this long is removed, 0 to keep streams
+	PendingTimeout    time.Duration // how long a session waits for its stream to appear, 0 to wait forever
+	InventoryFile     string        // JSON file naming and labelling streams, see LoadInventory
+	VirtualStreams    []VirtualStream
+	MergeJitter       time.Duration // how long virtual streams hold packets to merge them in timestamp order
+}
@@ -27,8 +27,8 @@ func NewForwardSessionUDP(fsm *ForwardSessionManager, key StreamKey, streamID st
 
 	destIP := net.ParseIP(string(cfg["dest_ip"].(string)))
 	destPort := uint16(cfg["dest_port"].(float64))
-	if destIP.To4() == nil {
-		return nil, fmt.Errorf("only IPv4 is supported for UDP forwarding")
+	if destIP == nil {
+		return nil, fmt.Errorf("invalid destination IP for UDP forwarding: %v", cfg["dest_ip"])
 	}
 	addr := &net.UDPAddr{
 		IP:   destIP,
@@ -43,7 +43,9 @@ func NewForwardSessionUDP(fsm *ForwardSessionManager, key StreamKey, streamID st
 	go func() {
 		for msg := range ch {
 			if msg.Type == internal.ForwardSessionMsgTypePacket {
-				if _, err := conn.Write(msg.Packet); err != nil {
+				_, err := conn.Write(msg.Buffer.Data)
+				msg.Buffer.Release()
+				if err != nil {
 					if errors.Is(err, syscall.ECONNREFUSED) {
 						// Only report ECONNREFUSED once
 						if logEconnrefused {
@@ -60,6 +62,7 @@ func NewForwardSessionUDP(fsm *ForwardSessionManager, key StreamKey, streamID st
 			}
 		}
 		conn.Close()
+		fsm.DeleteForwardSession(fs_udp)
 	}()
 	return fs_udp, nil
 }
@@ -0,0 +1,119 @@
+package forward
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+type StreamMeta = internal.StreamMeta
+
+// InventoryEntry names the stream of an exporter and ERSPAN ID. Without an ERSPAN ID the
+// entry applies to all streams of the exporter, without an encap to all encapsulations.
+type InventoryEntry struct {
+	SrcIP    string  `json:"src_ip"`
+	ErspanID *uint32 `json:"erspan_id,omitempty"`
+	Encap    string  `json:"encap,omitempty"`
+	StreamMeta
+}
+
+// inventoryFile is the format of the inventory file
+type inventoryFile struct {
+	Streams []InventoryEntry `json:"streams"`
+}
+
+type inventoryKey struct {
+	src      netip.Addr
+	id       uint32
+	anyID    bool
+	encap    internal.Encap
+	anyEncap bool
+}
+
+// Inventory maps stream keys to their names, sites, descriptions and labels
+type Inventory struct {
+	entries map[inventoryKey]*StreamMeta
+}
+
+// LoadInventory reads an inventory file
+func LoadInventory(path string) (*Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f inventoryFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	inv := &Inventory{entries: make(map[inventoryKey]*StreamMeta, len(f.Streams))}
+	for i, e := range f.Streams {
+		addr, err := netip.ParseAddr(e.SrcIP)
+		if err != nil {
+			return nil, fmt.Errorf("%s: stream %d: invalid src_ip: %w", path, i, err)
+		}
+		ik := inventoryKey{src: addr.Unmap(), anyID: e.ErspanID == nil, anyEncap: e.Encap == ""}
+		if e.ErspanID != nil {
+			ik.id = *e.ErspanID
+		}
+		if e.Encap != "" {
+			if ik.encap, err = internal.ParseEncap(e.Encap); err != nil {
+				return nil, fmt.Errorf("%s: stream %d: %w", path, i, err)
+			}
+		}
+		if _, dup := inv.entries[ik]; dup {
+			return nil, fmt.Errorf("%s: stream %d: duplicate entry for %s", path, i, e.SrcIP)
+		}
+		meta := e.StreamMeta
+		inv.entries[ik] = &meta
+	}
+	return inv, nil
+}
+
+// Len returns the number of entries in the inventory
+func (inv *Inventory) Len() int {
+	return len(inv.entries)
+}
+
+// Lookup returns the most specific entry for a stream, or nil if there is none
+func (inv *Inventory) Lookup(key StreamKey) *StreamMeta {
+	if inv == nil {
+		return nil
+	}
+	for _, ik := range []inventoryKey{
+		{src: key.SrcIP, id: key.ErspanID, encap: key.Encap},
+		{src: key.SrcIP, id: key.ErspanID, anyEncap: true},
+		{src: key.SrcIP, anyID: true, encap: key.Encap},
+		{src: key.SrcIP, anyID: true, anyEncap: true},
+	} {
+		if meta, ok := inv.entries[ik]; ok {
+			return meta
+		}
+	}
+	return nil
+}
+
+// ReloadInventory loads the configured inventory file, keeping the current inventory if
+// the file cannot be loaded
+func (fsm *ForwardSessionManager) ReloadInventory() error {
+	if fsm.config.InventoryFile == "" {
+		return nil
+	}
+	inv, err := LoadInventory(fsm.config.InventoryFile)
+	if err != nil {
+		return err
+	}
+	fsm.inventory.Store(inv)
+	fsm.logger.Info("loaded inventory", "file", fsm.config.InventoryFile, "entries", inv.Len())
+	return nil
+}
+
+// streamMeta returns the inventory entry of a stream
+func (fsm *ForwardSessionManager) streamMeta(key StreamKey) StreamMeta {
+	if meta := fsm.inventory.Load().Lookup(key); meta != nil {
+		return *meta
+	}
+	return StreamMeta{}
+}
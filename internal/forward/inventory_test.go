@@ -0,0 +1,131 @@
+package forward
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+func writeInventory(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInventoryLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	writeInventory(t, path, `{"streams": [
+		{"src_ip": "10.1.2.3", "name": "exporter"},
+		{"src_ip": "10.1.2.3", "erspan_id": 42, "name": "session"},
+		{"src_ip": "10.1.2.3", "encap": "vxlan", "name": "vxlan exporter"},
+		{"src_ip": "::ffff:10.1.2.3", "erspan_id": 42, "encap": "vxlan", "name": "vxlan session"}
+	]}`)
+	inv, err := LoadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vxlan := testKey
+	vxlan.Encap = internal.EncapVXLAN
+	otherID, otherVXLAN, otherSrc := testKey, vxlan, testKey
+	otherID.ErspanID++
+	otherVXLAN.ErspanID++
+	otherSrc.SrcIP = netip.MustParseAddr("10.1.2.4")
+	tests := []struct {
+		key  StreamKey
+		name string
+	}{
+		{testKey, "session"},
+		{otherID, "exporter"},
+		{vxlan, "vxlan session"},
+		{otherVXLAN, "vxlan exporter"},
+		{otherSrc, ""},
+	}
+	for _, tt := range tests {
+		name := ""
+		if meta := inv.Lookup(tt.key); meta != nil {
+			name = meta.Name
+		}
+		if name != tt.name {
+			t.Errorf("%s: %q, want %q", tt.key, name, tt.name)
+		}
+	}
+
+	for _, bad := range []string{
+		`{"streams": [{"src_ip": "10.1.2"}]}`,
+		`{"streams": [{"src_ip": "10.1.2.3", "encap": "foo"}]}`,
+		`{"streams": [{"src_ip": "10.1.2.3"}, {"src_ip": "10.1.2.3"}]}`,
+		`{"streams": `,
+	} {
+		writeInventory(t, path, bad)
+		if _, err := LoadInventory(path); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+}
+
+func TestReloadInventory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	writeInventory(t, path, `{"streams": [
+		{"src_ip": "10.1.2.3", "erspan_id": 42, "name": "uplink", "site": "London", "labels": {"role": "wan"}}
+	]}`)
+	fsm := newTestManager(&Config{InventoryFile: path})
+	if err := fsm.ReloadInventory(); err != nil {
+		t.Fatal(err)
+	}
+	fsm.updateStream(&PacketInfo{Key: testKey, Timestamp: time.Now()}, 100)
+	other := testKey
+	other.ErspanID++
+	fsm.updateStream(&PacketInfo{Key: other, Timestamp: time.Now()}, 100)
+
+	search := func(query string) []string {
+		var names []string
+		for _, si := range fsm.Search(query) {
+			names = append(names, si.Key().String()+" "+si.Name)
+		}
+		return names
+	}
+	if got := search("role=wan london"); len(got) != 1 || got[0] != "10.1.2.3/42 uplink" {
+		t.Errorf("search before reload: %q", got)
+	}
+
+	// The new inventory renames the stream, moves the label and drops the site
+	writeInventory(t, path, `{"streams": [
+		{"src_ip": "10.1.2.3", "erspan_id": 42, "name": "backup"},
+		{"src_ip": "10.1.2.3", "erspan_id": 43, "name": "uplink", "labels": {"role": "wan"}}
+	]}`)
+	if err := fsm.ReloadInventory(); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("role=wan"); len(got) != 1 || got[0] != "10.1.2.3/43 uplink" {
+		t.Errorf("search after reload: %q", got)
+	}
+	if got := search("london"); len(got) != 0 {
+		t.Errorf("removed site still matches: %q", got)
+	}
+	if si, _ := fsm.GetStreamByID(testKey.ID()); si == nil || si.Name != "backup" || si.Labels != nil {
+		t.Errorf("stream after reload: %+v", si)
+	}
+
+	// A broken file keeps the current inventory
+	writeInventory(t, path, `{"streams": [`)
+	if err := fsm.ReloadInventory(); err == nil {
+		t.Error("no error for a broken inventory")
+	}
+	if si, _ := fsm.GetStreamByID(testKey.ID()); si == nil || si.Name != "backup" {
+		t.Errorf("stream after failed reload: %+v", si)
+	}
+
+	// An empty inventory removes all metadata
+	writeInventory(t, path, `{"streams": []}`)
+	if err := fsm.ReloadInventory(); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("uplink"); len(got) != 0 {
+		t.Errorf("search after emptying the inventory: %q", got)
+	}
+}
@@ -1,18 +1,28 @@
 package forward
 
 import (
-	"crypto/rand"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"anthonyuk.dev/erspan-hub/internal"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type ForwardSessionManager struct {
-	logger  *slog.Logger
-	mu      sync.RWMutex
-	Streams map[StreamKey]*StreamInfo
+	config         *Config
+	logger         *slog.Logger
+	streams        *streamRegistry
+	access         *accessControl
+	sessionMu      sync.Mutex // serialises changes to the forward sessions of streams
+	sessions       map[ForwardSessionChannel]*sessionEntry
+	pending        map[StreamKey][]*sessionEntry // sessions waiting for a stream to appear
+	wildcards      []*sessionEntry               // sessions attached to any new matching stream
+	inventory      atomic.Pointer[Inventory]
+	mergers        []*merger // of the virtual streams
+	droppedPackets *prometheus.CounterVec
 }
 
 type ForwardSessionFactory func(fsm *ForwardSessionManager, key StreamKey, streamID string, handlerType string, filter string, cfg map[string]any) (fs ForwardSessionChannel, err error)
@@ -24,76 +34,120 @@ func (fsm *ForwardSessionManager) Logger() *slog.Logger {
 	return fsm.logger
 }
 
-func (fsm *ForwardSessionManager) Lock() {
-	fsm.mu.Lock()
-}
-
-func (fsm *ForwardSessionManager) Unlock() {
-	fsm.mu.Unlock()
+// NewForwardSessionManager creates a new ForwardSessionManager
+func NewForwardSessionManager(cfg *Config, logger *slog.Logger) *ForwardSessionManager {
+	if cfg.QueueLength <= 0 {
+		cfg.QueueLength = 1
+	}
+	fsm := &ForwardSessionManager{
+		config:   cfg,
+		logger:   logger,
+		streams:  newStreamRegistry(),
+		access:   newAccessControl(cfg),
+		sessions: make(map[ForwardSessionChannel]*sessionEntry),
+		pending:  make(map[StreamKey][]*sessionEntry),
+		droppedPackets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "forward_session_dropped_packets",
+			Help: "Packets dropped because a forward session's queue was full",
+		}, append(streamLabels, "type")),
+	}
+	for _, vs := range cfg.VirtualStreams {
+		fsm.mergers = append(fsm.mergers, fsm.newVirtualStream(vs))
+	}
+	return fsm
 }
 
-func (fsm *ForwardSessionManager) RLock() {
-	fsm.mu.RLock()
+// Snapshot returns the current state of all streams
+func (fsm *ForwardSessionManager) Snapshot() []*StreamInfo {
+	streams := fsm.streams.all()
+	list := make([]*StreamInfo, 0, len(streams))
+	for _, s := range streams {
+		list = append(list, fsm.snapshot(s))
+	}
+	return list
 }
 
-func (fsm *ForwardSessionManager) RUnlock() {
-	fsm.mu.RUnlock()
+// Search returns the streams matching a query, see StreamInfo.Matches
+func (fsm *ForwardSessionManager) Search(query string) []*StreamInfo {
+	list := fsm.Snapshot()
+	if query == "" {
+		return list
+	}
+	matches := list[:0]
+	for _, si := range list {
+		if si.Matches(query) {
+			matches = append(matches, si)
+		}
+	}
+	return matches
 }
 
-// NewForwardSessionManager creates a new ForwardSessionManager
-func NewForwardSessionManager(logger *slog.Logger) *ForwardSessionManager {
-	return &ForwardSessionManager{
-		logger:  logger,
-		mu:      sync.RWMutex{},
-		Streams: make(map[StreamKey]*StreamInfo),
+// snapshot returns the state of a stream with its inventory entry, or the description of a virtual stream
+func (fsm *ForwardSessionManager) snapshot(s *stream) *StreamInfo {
+	si := s.snapshot(fsm.config)
+	if s.merger != nil {
+		si.StreamMeta = s.merger.meta()
+	} else {
+		si.StreamMeta = fsm.streamMeta(s.key)
 	}
+	return si
 }
 
 func (fsm *ForwardSessionManager) GetStream(key StreamKey) (si *StreamInfo, ok bool) {
-	fsm.RLock()
-	s, ok := fsm.Streams[key]
-	fsm.RUnlock()
-	return s, ok
+	s := fsm.streams.get(key)
+	if s == nil {
+		return nil, false
+	}
+	return fsm.snapshot(s), true
 }
 
+// GetStreamByID finds a stream by ID, or a virtual stream by name
 func (fsm *ForwardSessionManager) GetStreamByID(id string) (si *StreamInfo, key StreamKey) {
-	fsm.RLock()
-	defer fsm.RUnlock()
-	for k, s := range fsm.Streams {
-		if s.ID == id {
-			return s, k
+	for _, s := range fsm.streams.all() {
+		if s.id == id || (s.merger != nil && s.merger.config.Name == id) {
+			return fsm.snapshot(s), s.key
 		}
 	}
 	return nil, NullStreamKey
 }
 
-// UpdateStream adds a discovered stream to the streams registry
-func (fsm *ForwardSessionManager) UpdateStream(key StreamKey, t time.Time, bytes int) (si *StreamInfo) {
-	fsm.Lock()
-	si, exists := fsm.Streams[key]
-	defer fsm.Unlock()
-
-	if exists {
-		si.LastSeen = t
-		si.Packets++
-		si.Bytes += uint64(bytes)
-		return si
+// GetStreamTimestampSource returns the timestamp source used for the last packet of a stream
+func (fsm *ForwardSessionManager) GetStreamTimestampSource(key StreamKey) internal.TimestampSource {
+	if s := fsm.streams.get(key); s != nil {
+		return s.getTimestampSource()
 	}
+	return ""
+}
 
-	si = &StreamInfo{
-		ID:              rand.Text(),
-		SrcIP:           key.SrcIP,
-		ErspanID:        key.ErspanID,
-		FirstSeen:       t,
-		LastSeen:        t,
-		Packets:         1,
-		Bytes:           uint64(bytes),
-		ForwardSessions: make(internal.ForwardSessionSet),
+// updateStream accounts for a packet in the streams registry, registering the stream if it is new.
+// It returns nil if the packet's exporter may not create a stream.
+func (fsm *ForwardSessionManager) updateStream(pi *PacketInfo, bytes int) *stream {
+	now := time.Now()
+	s := fsm.streams.get(pi.Key)
+	created := false
+	if s == nil {
+		reason := fsm.access.check(pi.Key)
+		if reason == "" {
+			s, created = fsm.streams.getOrCreate(pi.Key, fsm.access.maxStreams, func() *stream {
+				s := newStream(pi.Key, now)
+				s.mergers = fsm.mergersFor(pi.Key)
+				return s
+			})
+			if s == nil {
+				reason = RejectMaxStreams
+			}
+		}
+		if reason != "" {
+			fsm.access.reject(pi, bytes, reason)
+			return nil
+		}
 	}
-	fsm.Streams[key] = si
-	fsm.logger.Info("registered new stream", "stream_id", si.ID, "key", key.String())
-	// TODO: Link any existing sessions for this stream
-	return si
+	s.update(pi, bytes, now)
+	if created {
+		fsm.logger.Info("registered new stream", "stream_id", s.id, "key", pi.Key.String(), "name", fsm.streamMeta(pi.Key).Name, "erspan_version", pi.ErspanVersion)
+		fsm.attachPending(s)
+	}
+	return s
 }
 
 func RegisterForwardSessionType(name string, factory ForwardSessionFactory) {
@@ -1,7 +1,6 @@
 package forward
 
 import (
-	"sync"
 	"time"
 
 	"anthonyuk.dev/erspan-hub/internal"
@@ -11,48 +10,119 @@ import (
 
 type ForwardSessionMsg = internal.ForwardSessionMsg
 type ForwardSessionMsgType = internal.ForwardSessionMsgType
+type PacketInfo = internal.PacketInfo
+
+// ProcessPacket accounts for a packet and forwards it to the sessions of its stream.
+// It takes over the caller's reference to the buffer.
+func (fsm *ForwardSessionManager) ProcessPacket(pi *PacketInfo, pb *internal.PacketBuffer) {
+	defer pb.Release()
 
-func (fsm *ForwardSessionManager) ProcessPacket(key StreamKey, timestamp time.Time, packet []byte) {
 	// Register or update discovered stream
-	var si = fsm.UpdateStream(key, timestamp, len(packet))
+	var s = fsm.updateStream(pi, len(pb.Data))
+	if s == nil {
+		return
+	}
 
 	// Forward to matching sessions
-	fsm.ForwardToSessions(si, timestamp, packet)
+	fsm.forwardToSessions(s, pi.Timestamp, pb)
+	for _, m := range s.mergers {
+		m.push(pi, pb)
+	}
 }
 
-// ForwardToSessions forwards a packet to all matching forwarding sessions
-func (fsm *ForwardSessionManager) ForwardToSessions(si *StreamInfo, timestamp time.Time, payload []byte) {
-	// Copy the array of relevant channels (which pass the BPF filter) to avoid holding the lock while sending
-	fsm.RLock()
-	channels := make([]chan ForwardSessionMsg, 0, len(si.ForwardSessions))
-	gci := gopacket.CaptureInfo{Timestamp: timestamp, CaptureLength: len(payload), Length: len(payload)}
-	for sess := range si.ForwardSessions {
-		schan := sess.(ForwardSessionChannel)
-		schan.GetStats().TotalPackets.Add(1)
-		if schan.GetBpfFilter() != nil && !schan.GetBpfFilter().Matches(gci, payload) {
-			schan.GetStats().FilteredPackets.Add(1)
-			continue
-		}
-		channels = append(channels, schan.GetChannel())
+// forwardToSessions queues a packet for all matching forwarding sessions of a stream.
+// Each session that is sent the packet gets its own reference to the buffer.
+// A full queue is handled according to the drop policy, the block policy waits at most
+// BlockTimeout per packet for all sessions together.
+func (fsm *ForwardSessionManager) forwardToSessions(s *stream, timestamp time.Time, pb *internal.PacketBuffer) {
+	sessions := s.forwardSessions()
+	if len(sessions) == 0 {
+		return
 	}
-	fsm.RUnlock()
-
+	payload := pb.Data
+	gci := gopacket.CaptureInfo{Timestamp: timestamp, CaptureLength: len(payload), Length: len(payload)}
 	msg := ForwardSessionMsg{
 		Type:   internal.ForwardSessionMsgTypePacket,
-		Packet: payload,
+		Buffer: pb,
 		Time:   timestamp,
+		Key:    s.key,
 	}
-	wg := sync.WaitGroup{}
-	for _, ch := range channels {
-		wg.Add(1)
-		go func(ch chan ForwardSessionMsg) {
-			defer wg.Done()
+
+	var deadline time.Time
+	s.sendMu.RLock()
+	defer s.sendMu.RUnlock()
+	for _, sess := range sessions {
+		sess.GetStats().TotalPackets.Add(1)
+		if sess.GetBpfFilter() != nil && !sess.GetBpfFilter().Matches(gci, payload) {
+			sess.GetStats().FilteredPackets.Add(1)
+			continue
+		}
+		pb.Retain(1)
+		if fsm.enqueue(sess.GetChannel(), msg, &deadline) {
+			sess.GetStats().DroppedPackets.Add(1)
+			fsm.droppedPackets.WithLabelValues(append(streamLabelValues(s.key), sess.GetType())...).Inc()
+		}
+	}
+}
+
+// enqueue puts a packet message on a session queue and reports whether a packet,
+// either this one or an older one, was dropped. A dropped packet's buffer is released.
+// The block policy waits until deadline, which is set on the first wait for a packet.
+func (fsm *ForwardSessionManager) enqueue(ch chan ForwardSessionMsg, msg ForwardSessionMsg, deadline *time.Time) (dropped bool) {
+	switch fsm.config.DropPolicy {
+	case DropOldest:
+		for {
 			select {
 			case ch <- msg:
-			case <-time.After(100 * time.Millisecond):
-				fsm.logger.Warn("Dropping packet for slow forward session")
+				return dropped
+			default:
 			}
-		}(ch)
+			select {
+			case old := <-ch:
+				if old.Type != internal.ForwardSessionMsgTypePacket {
+					// Never drop a close message, drop the new packet instead
+					select {
+					case ch <- old:
+					default:
+					}
+					msg.Buffer.Release()
+					return true
+				}
+				old.Buffer.Release()
+				dropped = true
+			default:
+			}
+		}
+	case DropBlock:
+		select {
+		case ch <- msg:
+			return false
+		default:
+		}
+		if deadline.IsZero() {
+			*deadline = time.Now().Add(fsm.config.BlockTimeout)
+		}
+		wait := time.Until(*deadline)
+		if wait <= 0 {
+			msg.Buffer.Release()
+			return true
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case ch <- msg:
+			return false
+		case <-timer.C:
+			msg.Buffer.Release()
+			return true
+		}
+	default:
+		select {
+		case ch <- msg:
+			return false
+		default:
+			msg.Buffer.Release()
+			return true
+		}
 	}
-	wg.Wait()
 }
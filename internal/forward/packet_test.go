@@ -0,0 +1,174 @@
+package forward
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testBufferSize is above the largest pool, so released buffers are not reused and
+// their reference counts can be checked afterwards
+const testBufferSize = 70000
+
+func newTestBuffer(b byte) *internal.PacketBuffer {
+	return internal.NewPacketBuffer(bytes.Repeat([]byte{b}, testBufferSize))
+}
+
+func newTestSession(queue int) *ForwardSessionBase {
+	return &ForwardSessionBase{
+		StreamKey: testKey,
+		Type:      "test",
+		Channel:   make(chan ForwardSessionMsg, queue),
+		Stats:     &ForwardSessionStats{},
+	}
+}
+
+// newTestStream returns a stream that is forwarded to sessions
+func newTestStream(sessions ...ForwardSessionChannel) *stream {
+	s := newStream(testKey, time.Now())
+	for _, fs := range sessions {
+		s.addSession(fs)
+	}
+	return s
+}
+
+// send forwards a packet like ProcessPacket, which drops its own reference afterwards
+func send(fsm *ForwardSessionManager, s *stream, pb *internal.PacketBuffer) {
+	fsm.forwardToSessions(s, time.Now(), pb)
+	pb.Release()
+}
+
+// queued returns the first byte of each queued packet, or 'C' for a close message
+func queued(fs *ForwardSessionBase) []byte {
+	var list []byte
+	for {
+		select {
+		case msg := <-fs.Channel:
+			if msg.Type != internal.ForwardSessionMsgTypePacket {
+				list = append(list, 'C')
+				continue
+			}
+			list = append(list, msg.Buffer.Data[0])
+			msg.Buffer.Release()
+		default:
+			return list
+		}
+	}
+}
+
+func droppedCounter(fsm *ForwardSessionManager) float64 {
+	return testutil.ToFloat64(fsm.droppedPackets.WithLabelValues(append(streamLabelValues(testKey), "test")...))
+}
+
+func TestDropNewest(t *testing.T) {
+	fsm := newTestManager(&Config{QueueLength: 2, DropPolicy: DropNewest})
+	fs := newTestSession(2)
+	s := newTestStream(fs)
+	pbs := []*internal.PacketBuffer{newTestBuffer('a'), newTestBuffer('b'), newTestBuffer('c')}
+	for _, pb := range pbs {
+		send(fsm, s, pb)
+	}
+	for i, want := range []int{1, 1, 0} {
+		if refs := pbs[i].Refs(); refs != want {
+			t.Errorf("packet %d: %d references, want %d", i, refs, want)
+		}
+	}
+	if got := queued(fs); string(got) != "ab" {
+		t.Errorf("queued %q, want %q", got, "ab")
+	}
+	if n := fs.Stats.DroppedPackets.Load(); n != 1 {
+		t.Errorf("%d dropped packets, want 1", n)
+	}
+	if n := droppedCounter(fsm); n != 1 {
+		t.Errorf("dropped packets counter %v, want 1", n)
+	}
+	for i, pb := range pbs {
+		if refs := pb.Refs(); refs != 0 {
+			t.Errorf("packet %d: %d references after draining", i, refs)
+		}
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	fsm := newTestManager(&Config{QueueLength: 2, DropPolicy: DropOldest})
+	fs := newTestSession(2)
+	s := newTestStream(fs)
+	pbs := []*internal.PacketBuffer{newTestBuffer('a'), newTestBuffer('b'), newTestBuffer('c')}
+	for _, pb := range pbs {
+		send(fsm, s, pb)
+	}
+	for i, want := range []int{0, 1, 1} {
+		if refs := pbs[i].Refs(); refs != want {
+			t.Errorf("packet %d: %d references, want %d", i, refs, want)
+		}
+	}
+	if got := queued(fs); string(got) != "bc" {
+		t.Errorf("queued %q, want %q", got, "bc")
+	}
+	if n := droppedCounter(fsm); n != 1 {
+		t.Errorf("dropped packets counter %v, want 1", n)
+	}
+}
+
+func TestDropOldestKeepsClose(t *testing.T) {
+	fsm := newTestManager(&Config{QueueLength: 2, DropPolicy: DropOldest})
+	fs := newTestSession(2)
+	s := newTestStream(fs)
+	fs.Channel <- ForwardSessionMsg{Type: internal.ForwardSessionMsgTypeClose}
+	a, b := newTestBuffer('a'), newTestBuffer('b')
+	send(fsm, s, a)
+	send(fsm, s, b)
+	if a.Refs() != 1 || b.Refs() != 0 {
+		t.Errorf("references %d and %d, want 1 and 0", a.Refs(), b.Refs())
+	}
+	if got := queued(fs); !bytes.Contains(got, []byte{'C'}) || !bytes.Contains(got, []byte{'a'}) {
+		t.Errorf("queued %q, want the close message and packet a", got)
+	}
+	if n := fs.Stats.DroppedPackets.Load(); n != 1 {
+		t.Errorf("%d dropped packets, want 1", n)
+	}
+}
+
+func TestDropBlock(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	fsm := newTestManager(&Config{QueueLength: 1, DropPolicy: DropBlock, BlockTimeout: timeout})
+	slow1, slow2, reader := newTestSession(1), newTestSession(1), newTestSession(1)
+	s := newTestStream(slow1, slow2, reader)
+	send(fsm, s, newTestBuffer('a'))
+
+	// The reader makes room while the packet waits, the slow sessions never do
+	go func() {
+		time.Sleep(timeout / 4)
+		queued(reader)
+	}()
+	pb := newTestBuffer('b')
+	start := time.Now()
+	send(fsm, s, pb)
+	elapsed := time.Since(start)
+
+	// The deadline is shared, two slow sessions do not wait twice as long
+	if elapsed < timeout || elapsed > timeout*3/2 {
+		t.Errorf("blocked for %s, want %s", elapsed, timeout)
+	}
+	if refs := pb.Refs(); refs != 1 {
+		t.Errorf("%d references, want 1 held by the reader", refs)
+	}
+	if got := queued(reader); string(got) != "b" {
+		t.Errorf("reader queued %q, want %q", got, "b")
+	}
+	for _, fs := range []*ForwardSessionBase{slow1, slow2} {
+		if n := fs.Stats.DroppedPackets.Load(); n != 1 {
+			t.Errorf("%d dropped packets, want 1", n)
+		}
+	}
+	if n := droppedCounter(fsm); n != 2 {
+		t.Errorf("dropped packets counter %v, want 2", n)
+	}
+	if refs := pb.Refs(); refs != 0 {
+		t.Errorf("%d references after draining", refs)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"runtime"
 	"time"
 
+	"anthonyuk.dev/erspan-hub/internal"
+
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcapgo"
@@ -35,34 +37,97 @@ func (pw *PcapWriter) WritePacket(pkt []byte, timestamp time.Time) error {
 	}, pkt)
 }
 
+// PcapNgWriter writes a pcapng stream with one interface per ERSPAN stream
 type PcapNgWriter struct {
-	NgWriter *pcapgo.NgWriter
-	IOWriter io.Writer
+	NgWriter   *pcapgo.NgWriter // nil until the first interface is added
+	IOWriter   io.Writer
+	filter     string
+	tsSource   func(StreamKey) internal.TimestampSource
+	interfaces map[StreamKey]int
 }
 
-func NewPcapNgWriter(w io.Writer, fs ForwardSessionChannel) (*PcapNgWriter, error) {
-	intf := MyNgInterface
-	intf.Name = "erspan-1"
-	intf.Description = fmt.Sprintf("ERSPAN-Hub Stream: %s", fs.GetStreamKey().String())
-	intf.Filter = fs.GetFilterString()
-	ngw, err := pcapgo.NewNgWriterInterface(w, intf, MyNgWriterOptions)
-	if err != nil {
+// NewPcapNgWriter writes the interface of the session's stream
+func NewPcapNgWriter(w io.Writer, fs ForwardSessionChannel, tsSource internal.TimestampSource) (*PcapNgWriter, error) {
+	pw := NewPcapNgStreamsWriter(w, fs, func(StreamKey) internal.TimestampSource { return tsSource })
+	if _, err := pw.AddInterface(fs.GetStreamKey()); err != nil {
 		return nil, err
 	}
+	return pw, nil
+}
+
+// NewPcapNgStreamsWriter writes an interface for each stream of a multi-stream session, see
+// AddInterface. Nothing is written before the first interface. tsSource returns the timestamp
+// source of a stream.
+func NewPcapNgStreamsWriter(w io.Writer, fs ForwardSessionChannel, tsSource func(StreamKey) internal.TimestampSource) *PcapNgWriter {
 	return &PcapNgWriter{
-		NgWriter: ngw,
-		IOWriter: w,
-	}, nil
+		IOWriter:   w,
+		filter:     fs.GetFilterString(),
+		tsSource:   tsSource,
+		interfaces: make(map[StreamKey]int),
+	}
+}
+
+// AddInterface writes an Interface Description Block for a stream unless it already has one,
+// and returns the interface ID
+func (pw *PcapNgWriter) AddInterface(key StreamKey) (int, error) {
+	if id, ok := pw.interfaces[key]; ok {
+		return id, nil
+	}
+	intf := MyNgInterface
+	intf.Name = fmt.Sprintf("erspan-%d", len(pw.interfaces)+1)
+	intf.Description = fmt.Sprintf("ERSPAN-Hub Stream: %s", key.String())
+	if tsSource := pw.tsSource(key); tsSource != "" {
+		intf.Description += fmt.Sprintf(" (timestamps: %s)", tsSource)
+	}
+	intf.Filter = pw.filter
+	id := 0
+	if pw.NgWriter == nil {
+		ngw, err := pcapgo.NewNgWriterInterface(pw.IOWriter, intf, MyNgWriterOptions)
+		if err != nil {
+			return 0, err
+		}
+		pw.NgWriter = ngw
+	} else {
+		var err error
+		if id, err = pw.NgWriter.AddInterface(intf); err != nil {
+			return 0, err
+		}
+	}
+	pw.interfaces[key] = id
+	return id, nil
 }
 
+// WritePacket writes a packet to the first interface
 func (pw *PcapNgWriter) WritePacket(pkt []byte, timestamp time.Time) error {
+	return pw.writePacket(0, pkt, timestamp)
+}
+
+// WriteStreamPacket writes a packet to the interface of its stream, adding the interface if needed
+func (pw *PcapNgWriter) WriteStreamPacket(key StreamKey, pkt []byte, timestamp time.Time) error {
+	id, err := pw.AddInterface(key)
+	if err != nil {
+		return err
+	}
+	return pw.writePacket(id, pkt, timestamp)
+}
+
+func (pw *PcapNgWriter) writePacket(id int, pkt []byte, timestamp time.Time) error {
 	return pw.NgWriter.WritePacket(gopacket.CaptureInfo{
-		CaptureLength: len(pkt),
-		Length:        len(pkt),
-		Timestamp:     timestamp,
+		CaptureLength:  len(pkt),
+		Length:         len(pkt),
+		Timestamp:      timestamp,
+		InterfaceIndex: id,
 	}, pkt)
 }
 
+// Flush writes out buffered data
+func (pw *PcapNgWriter) Flush() error {
+	if pw.NgWriter == nil {
+		return nil
+	}
+	return pw.NgWriter.Flush()
+}
+
 var MyNgWriterOptions = pcapgo.NgWriterOptions{
 	SectionInfo: pcapgo.NgSectionInfo{
 		Hardware:    runtime.GOARCH,
@@ -0,0 +1,115 @@
+package forward
+
+import (
+	"slices"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+// sessionEntry tracks the streams of a forward session
+type sessionEntry struct {
+	fs        ForwardSessionChannel
+	selectors []StreamSelector
+	streams   []*stream   // streams the session is attached to
+	expired   []*stream   // streams removed by the reaper that may still be queuing packets
+	timer     *time.Timer // closes the session if no stream appears in time
+}
+
+// selects reports whether the session subscribes to a stream
+func (e *sessionEntry) selects(key StreamKey) bool {
+	return slices.ContainsFunc(e.selectors, func(sel StreamSelector) bool { return sel.Matches(key) })
+}
+
+// hasStream reports whether the session is attached to the stream of a key
+func (e *sessionEntry) hasStream(key StreamKey) bool {
+	return slices.ContainsFunc(e.streams, func(s *stream) bool { return s.key == key })
+}
+
+// waitTimeout returns the wait timeout requested in a forward session config as
+// "wait_timeout" seconds, or the configured default
+func (fsm *ForwardSessionManager) waitTimeout(cfg map[string]any) time.Duration {
+	if secs, ok := cfg["wait_timeout"].(float64); ok {
+		return time.Duration(secs * float64(time.Second))
+	}
+	return fsm.config.PendingTimeout
+}
+
+// attach adds a session to a stream. fsm.sessionMu must be held.
+func (fsm *ForwardSessionManager) attach(e *sessionEntry, s *stream) {
+	if slices.Contains(e.streams, s) {
+		return
+	}
+	s.addSession(e.fs)
+	e.streams = append(e.streams, s)
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	fsm.logger.Debug("Attached forward session", "stream_id", s.id, "fs", e.fs)
+}
+
+// addPending registers a session to be attached when the first packet of one of its streams
+// that is not there yet arrives. If the session has no stream after timeout it is closed.
+// fsm.sessionMu must be held.
+func (fsm *ForwardSessionManager) addPending(e *sessionEntry, timeout time.Duration) {
+	for _, sel := range e.selectors {
+		switch {
+		case !sel.IsKey():
+			if !slices.Contains(fsm.wildcards, e) {
+				fsm.wildcards = append(fsm.wildcards, e)
+			}
+		case !e.hasStream(sel.Key):
+			fsm.pending[sel.Key] = append(fsm.pending[sel.Key], e)
+		}
+	}
+	if len(e.streams) > 0 || timeout <= 0 {
+		return
+	}
+	e.timer = time.AfterFunc(timeout, func() {
+		fsm.sessionMu.Lock()
+		defer fsm.sessionMu.Unlock()
+		if fsm.sessions[e.fs] != e || len(e.streams) > 0 {
+			return
+		}
+		fsm.removePending(e)
+		fsm.logger.Info("stream did not appear, closing forward session", "stream_info_id", e.fs.GetStreamInfoID(), "timeout", timeout, "fs", e.fs)
+		// Nothing has been queued for a session without streams, so there is room
+		select {
+		case e.fs.GetChannel() <- ForwardSessionMsg{Type: internal.ForwardSessionMsgTypeClose}:
+		default:
+		}
+	})
+}
+
+// removePending stops a session from being attached to new streams.
+// fsm.sessionMu must be held.
+func (fsm *ForwardSessionManager) removePending(e *sessionEntry) {
+	for _, sel := range e.selectors {
+		if !sel.IsKey() {
+			continue
+		}
+		list := slices.DeleteFunc(fsm.pending[sel.Key], func(o *sessionEntry) bool { return o == e })
+		if len(list) == 0 {
+			delete(fsm.pending, sel.Key)
+		} else {
+			fsm.pending[sel.Key] = list
+		}
+	}
+	fsm.wildcards = slices.DeleteFunc(fsm.wildcards, func(o *sessionEntry) bool { return o == e })
+}
+
+// attachPending attaches the sessions waiting for a new stream
+func (fsm *ForwardSessionManager) attachPending(s *stream) {
+	fsm.sessionMu.Lock()
+	defer fsm.sessionMu.Unlock()
+	for _, e := range fsm.pending[s.key] {
+		fsm.attach(e, s)
+	}
+	delete(fsm.pending, s.key)
+	for _, e := range fsm.wildcards {
+		if e.selects(s.key) {
+			fsm.attach(e, s)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package forward
+
+import (
+	"slices"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+// how often the reaper looks for expired streams
+const reaperInterval = time.Second
+
+// RunReaper removes expired streams until done is closed
+func (fsm *ForwardSessionManager) RunReaper(done <-chan struct{}) {
+	if fsm.config.ExpiryTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(reaperInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			fsm.reapStreams(now)
+		case <-done:
+			return
+		}
+	}
+}
+
+// reapStreams removes the streams that have expired at now. Sessions left without a
+// stream are sent a close message once no packets are being queued for them, a session
+// deleted meanwhile waits for the message to be sent before closing its channel. Other
+// sessions stay attached to their remaining streams and wait for the stream to return.
+func (fsm *ForwardSessionManager) reapStreams(now time.Time) {
+	for _, s := range fsm.streams.all() {
+		if s.state(fsm.config, now) != internal.StreamStateExpired || !fsm.streams.remove(s) {
+			continue
+		}
+		fsm.sessionMu.Lock()
+		sessions := s.forwardSessions()
+		s.sessions.Store(&[]ForwardSessionChannel{})
+		var closing []ForwardSessionChannel
+		for _, fs := range sessions {
+			e := fsm.sessions[fs]
+			if e == nil {
+				continue
+			}
+			e.streams = slices.DeleteFunc(e.streams, func(o *stream) bool { return o == s })
+			e.expired = append(e.expired, s)
+			switch {
+			case len(e.streams) == 0:
+				fsm.removePending(e)
+				closing = append(closing, fs)
+			case slices.Contains(e.selectors, internal.KeySelector(s.key)):
+				fsm.pending[s.key] = append(fsm.pending[s.key], e)
+			}
+		}
+		// Taken before a deleted session can look for senders
+		s.sendMu.RLock()
+		fsm.sessionMu.Unlock()
+		fsm.logger.Info("removed expired stream", "stream_id", s.id, "key", s.key.String(), "forward_sessions", len(sessions), "closed", len(closing))
+		go func() {
+			fsm.sendControl(closing, internal.ForwardSessionMsgTypeClose)
+			s.sendMu.RUnlock()
+			// Once the packets being queued are done, deleting a session need not wait for the stream
+			s.sendMu.Lock()
+			s.sendMu.Unlock()
+			fsm.sessionMu.Lock()
+			for _, fs := range sessions {
+				if e := fsm.sessions[fs]; e != nil {
+					e.expired = slices.DeleteFunc(e.expired, func(o *stream) bool { return o == s })
+				}
+			}
+			fsm.sessionMu.Unlock()
+		}()
+	}
+}
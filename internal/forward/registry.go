@@ -0,0 +1,235 @@
+package forward
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+// number of shards in the stream registry, a power of two
+const streamShards = 64
+
+// stream is the live registry entry of a stream. The per-packet counters are
+// atomics, so accounting a packet never takes a lock shared with other streams.
+type stream struct {
+	id        string
+	key       StreamKey
+	firstSeen time.Time
+
+	packets         atomic.Uint64
+	bytes           atomic.Uint64
+	lastSeen        atomic.Int64 // UnixNano
+	erspanVersion   atomic.Uint32
+	timestampSource atomic.Pointer[internal.TimestampSource]
+
+	seqMu sync.Mutex
+	seq   internal.SequenceStats
+
+	// forward sessions of the stream, replaced as a whole when a session is added or removed
+	sessions atomic.Pointer[[]ForwardSessionChannel]
+	// held for reading while packets are queued to the sessions, so a removed
+	// session's channel can be closed once no sender is left
+	sendMu sync.RWMutex
+
+	merger  *merger   // feeds a virtual stream, nil for captured streams
+	mergers []*merger // virtual streams the stream is a member of, set before it is registered
+}
+
+// streamShard is one part of the stream registry. Lookups load the map without
+// locking; inserting a stream copies the map under the shard mutex.
+type streamShard struct {
+	mu      sync.Mutex
+	streams atomic.Pointer[map[StreamKey]*stream]
+}
+
+type streamRegistry struct {
+	shards [streamShards]streamShard
+	count  atomic.Int64 // captured streams, virtual streams are not counted
+}
+
+func newStreamRegistry() *streamRegistry {
+	r := &streamRegistry{}
+	for i := range r.shards {
+		m := make(map[StreamKey]*stream)
+		r.shards[i].streams.Store(&m)
+	}
+	return r
+}
+
+func (r *streamRegistry) shard(key StreamKey) *streamShard {
+	return &r.shards[key.Hash()&(streamShards-1)]
+}
+
+func (r *streamRegistry) get(key StreamKey) *stream {
+	return (*r.shard(key).streams.Load())[key]
+}
+
+// getOrCreate returns the stream for a key, calling create to make it if it is not registered yet.
+// It returns nil if the stream is new and max streams, if not 0, are already registered.
+// Only streams added here count towards max streams.
+func (r *streamRegistry) getOrCreate(key StreamKey, max int, create func() *stream) (s *stream, created bool) {
+	sh := r.shard(key)
+	if s := (*sh.streams.Load())[key]; s != nil {
+		return s, false
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	old := *sh.streams.Load()
+	if s := old[key]; s != nil {
+		return s, false
+	}
+	if n := r.count.Add(1); max > 0 && n > int64(max) {
+		r.count.Add(-1)
+		return nil, false
+	}
+	s = create()
+	sh.insert(old, s)
+	return s, true
+}
+
+// addVirtual registers a virtual stream. Virtual streams do not count towards max streams.
+func (r *streamRegistry) addVirtual(s *stream) {
+	sh := r.shard(s.key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.insert(*sh.streams.Load(), s)
+}
+
+// insert publishes a copy of the shard's streams old with s added. sh.mu must be held.
+func (sh *streamShard) insert(old map[StreamKey]*stream, s *stream) {
+	m := make(map[StreamKey]*stream, len(old)+1)
+	maps.Copy(m, old)
+	m[s.key] = s
+	sh.streams.Store(&m)
+}
+
+// remove takes a stream out of the registry, unless its key has been registered again
+func (r *streamRegistry) remove(s *stream) bool {
+	sh := r.shard(s.key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	old := *sh.streams.Load()
+	if old[s.key] != s {
+		return false
+	}
+	m := maps.Clone(old)
+	delete(m, s.key)
+	sh.streams.Store(&m)
+	if s.merger == nil {
+		r.count.Add(-1)
+	}
+	return true
+}
+
+// all returns every registered stream
+func (r *streamRegistry) all() []*stream {
+	var list []*stream
+	for i := range r.shards {
+		for _, s := range *r.shards[i].streams.Load() {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// newStream returns a stream first seen by the hub at now. The stream's times are hub
+// arrival times, packet timestamps may come from a clock the hub does not control.
+func newStream(key StreamKey, now time.Time) *stream {
+	s := &stream{
+		id:        key.ID(),
+		key:       key,
+		firstSeen: now,
+	}
+	// Set before the stream is published, the reaper would take a zero lastSeen for expired
+	s.lastSeen.Store(now.UnixNano())
+	s.sessions.Store(&[]ForwardSessionChannel{})
+	return s
+}
+
+// update accounts for a packet of the stream that arrived at now
+func (s *stream) update(pi *PacketInfo, bytes int, now time.Time) {
+	s.packets.Add(1)
+	s.bytes.Add(uint64(bytes))
+	s.lastSeen.Store(now.UnixNano())
+	if uint8(s.erspanVersion.Load()) != pi.ErspanVersion {
+		s.erspanVersion.Store(uint32(pi.ErspanVersion))
+	}
+	if tss := s.timestampSource.Load(); tss == nil || *tss != pi.TimestampSource {
+		tss := pi.TimestampSource // not &pi.TimestampSource, pi does not outlive the packet
+		s.timestampSource.Store(&tss)
+	}
+	if pi.HasSeq {
+		s.seqMu.Lock()
+		s.seq.TrackSequence(pi.Seq)
+		s.seqMu.Unlock()
+	}
+}
+
+func (s *stream) getTimestampSource() internal.TimestampSource {
+	if tss := s.timestampSource.Load(); tss != nil {
+		return *tss
+	}
+	return ""
+}
+
+func (s *stream) forwardSessions() []ForwardSessionChannel {
+	return *s.sessions.Load()
+}
+
+// addSession and removeSession must be called with the manager's session mutex held
+func (s *stream) addSession(fs ForwardSessionChannel) {
+	old := s.forwardSessions()
+	sessions := make([]ForwardSessionChannel, len(old), len(old)+1)
+	copy(sessions, old)
+	sessions = append(sessions, fs)
+	s.sessions.Store(&sessions)
+}
+
+func (s *stream) removeSession(fs ForwardSessionChannel) {
+	sessions := slices.DeleteFunc(slices.Clone(s.forwardSessions()), func(c ForwardSessionChannel) bool {
+		return c == fs
+	})
+	s.sessions.Store(&sessions)
+}
+
+// state returns the lifecycle state of the stream at now
+func (s *stream) state(cfg *Config, now time.Time) internal.StreamState {
+	quiet := now.Sub(time.Unix(0, s.lastSeen.Load()))
+	switch {
+	case cfg.ExpiryTimeout > 0 && quiet >= cfg.ExpiryTimeout && s.merger == nil: // virtual streams stay
+		return internal.StreamStateExpired
+	case cfg.IdleTimeout > 0 && quiet >= cfg.IdleTimeout:
+		return internal.StreamStateIdle
+	}
+	return internal.StreamStateActive
+}
+
+// snapshot returns a copy of the stream's current state
+func (s *stream) snapshot(cfg *Config) *StreamInfo {
+	si := &StreamInfo{
+		ID:              s.id,
+		SrcIP:           s.key.SrcIP,
+		ErspanID:        s.key.ErspanID,
+		Encap:           s.key.Encap,
+		ErspanVersion:   uint8(s.erspanVersion.Load()),
+		FirstSeen:       s.firstSeen,
+		LastSeen:        time.Unix(0, s.lastSeen.Load()),
+		Packets:         s.packets.Load(),
+		Bytes:           s.bytes.Load(),
+		TimestampSource: s.getTimestampSource(),
+		State:           s.state(cfg, time.Now()),
+	}
+	s.seqMu.Lock()
+	si.SequenceStats = s.seq
+	s.seqMu.Unlock()
+	sessions := s.forwardSessions()
+	si.ForwardSessions = make(internal.ForwardSessionSet, len(sessions))
+	for _, fs := range sessions {
+		si.ForwardSessions[fs] = struct{}{}
+	}
+	return si
+}
@@ -0,0 +1,110 @@
+package forward
+
+import (
+	"log/slog"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+var testKey = StreamKey{SrcIP: netip.MustParseAddr("10.1.2.3"), ErspanID: 42}
+
+func newTestManager(cfg *Config) *ForwardSessionManager {
+	return NewForwardSessionManager(cfg, slog.New(slog.DiscardHandler))
+}
+
+func TestStreamStateSkewedTimestamp(t *testing.T) {
+	cfg := &Config{IdleTimeout: 30 * time.Second, ExpiryTimeout: time.Hour}
+	for _, skew := range []time.Duration{-2 * time.Hour, -time.Minute, time.Minute, 2 * time.Hour} {
+		fsm := newTestManager(cfg)
+		pi := &PacketInfo{Key: testKey, Timestamp: time.Now().Add(skew), TimestampSource: internal.TimestampSourceErspan}
+		s := fsm.updateStream(pi, 100)
+		if s == nil {
+			t.Fatal("stream not created")
+		}
+		s = fsm.updateStream(pi, 100)
+		if state := s.state(cfg, time.Now()); state != internal.StreamStateActive {
+			t.Errorf("skew %s: state %s, want %s", skew, state, internal.StreamStateActive)
+		}
+		if d := time.Since(time.Unix(0, s.lastSeen.Load())); d < 0 || d > time.Minute {
+			t.Errorf("skew %s: last seen %s ago", skew, d)
+		}
+		if d := time.Since(s.firstSeen); d < 0 || d > time.Minute {
+			t.Errorf("skew %s: first seen %s ago", skew, d)
+		}
+	}
+}
+
+func TestRegistryGetOrCreateConcurrent(t *testing.T) {
+	r := newStreamRegistry()
+	const workers = 64
+	var (
+		wg      sync.WaitGroup
+		creates atomic.Int32
+		created atomic.Int32
+		streams [workers]*stream
+	)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, ok := r.getOrCreate(testKey, 0, func() *stream {
+				creates.Add(1)
+				return newStream(testKey, time.Now())
+			})
+			if ok {
+				created.Add(1)
+			}
+			streams[i] = s
+		}()
+	}
+	wg.Wait()
+	if creates.Load() != 1 || created.Load() != 1 {
+		t.Errorf("created %d times, reported %d times, want once", creates.Load(), created.Load())
+	}
+	for i, s := range streams {
+		if s == nil || s != streams[0] {
+			t.Fatalf("worker %d got another stream", i)
+		}
+	}
+	if n := r.count.Load(); n != 1 {
+		t.Errorf("count %d, want 1", n)
+	}
+	if !r.remove(streams[0]) || r.remove(streams[0]) {
+		t.Error("stream not removed exactly once")
+	}
+	if n := r.count.Load(); n != 0 {
+		t.Errorf("count %d after remove, want 0", n)
+	}
+}
+
+func TestRegistryMaxStreamsConcurrent(t *testing.T) {
+	r := newStreamRegistry()
+	const workers, max = 64, 10
+	var wg sync.WaitGroup
+	var created atomic.Int32
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := testKey
+			key.ErspanID = uint32(i)
+			if s, ok := r.getOrCreate(key, max, func() *stream { return newStream(key, time.Now()) }); ok {
+				created.Add(1)
+			} else if s != nil {
+				t.Errorf("stream %d existed already", i)
+			}
+			// Readers run alongside the copy-on-write inserts
+			r.get(key)
+			r.all()
+		}()
+	}
+	wg.Wait()
+	if created.Load() != max || r.count.Load() != max || len(r.all()) != max {
+		t.Errorf("created %d, count %d, %d streams, want %d", created.Load(), r.count.Load(), len(r.all()), max)
+	}
+}
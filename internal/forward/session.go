@@ -1,7 +1,10 @@
 package forward
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,8 +14,16 @@ import (
 	"github.com/google/gopacket/pcap"
 )
 
+// Errors returned when a forward session cannot be created, wrapped with details
+var (
+	ErrStreamNotFound   = errors.New("stream not found")
+	ErrStreamNotAllowed = errors.New("stream not allowed")
+	ErrBadFilter        = errors.New("bad filter")
+	ErrUnknownType      = errors.New("unknown forward session type")
+)
+
 func NewForwardSessionBase(fsm *ForwardSessionManager, key StreamKey, streamID string, handlerType string, filter string, cfg map[string]any) (fsb *ForwardSessionBase, err error) {
-	ch := make(chan ForwardSessionMsg, 32)
+	ch := make(chan ForwardSessionMsg, fsm.config.QueueLength)
 	sess := &ForwardSessionBase{
 		StreamKey:    key,
 		StreamInfoID: streamID,
@@ -25,7 +36,7 @@ func NewForwardSessionBase(fsm *ForwardSessionManager, key StreamKey, streamID s
 	if filter != "" {
 		bpfFilter, err := pcap.NewBPF(layers.LinkTypeEthernet, 65535, filter)
 		if err != nil {
-			return nil, fmt.Errorf("bad filter: %v", err)
+			return nil, fmt.Errorf("%w: %v", ErrBadFilter, err)
 		}
 		sess.Filter = bpfFilter
 	}
@@ -36,82 +47,193 @@ func NewForwardSessionBaseFactory(fsm *ForwardSessionManager, key StreamKey, str
 	return NewForwardSessionBase(fsm, key, streamID, handlerType, filter, cfg)
 }
 
-// CreateForwardSessionByStreamInfoID creates a new ForwardSession for the given StreamInfo ID
+// CreateForwardSessionByStreamInfoID creates a new ForwardSession for the given StreamInfo ID.
+// The stream can also be given as a key in the form returned by StreamKey.String. A key
+// without a stream is only waited for if cfg["wait_timeout"] is set, see
+// CreateForwardSessionByKey. Wildcards are only accepted by CreateForwardSessionByStreams.
 func (fsm *ForwardSessionManager) CreateForwardSessionByStreamInfoID(streamInfoID string, handlerType string, filter string, cfg map[string]any) (ForwardSessionChannel, error) {
 	_, key := fsm.GetStreamByID(streamInfoID)
+	wait := false
 	if key == NullStreamKey {
-		return nil, fmt.Errorf("stream not found: %s", streamInfoID)
+		k, err := internal.ParseStreamKey(streamInfoID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrStreamNotFound, streamInfoID)
+		}
+		key, wait = k, fsm.streams.get(k) == nil
+		if wait && cfg["wait_timeout"] == nil {
+			return nil, fmt.Errorf("%w: %s", ErrStreamNotFound, streamInfoID)
+		}
 	}
-	return fsm.createForwardSessionImpl(key, streamInfoID, handlerType, filter, cfg)
+	return fsm.createForwardSessionImpl([]StreamSelector{internal.KeySelector(key)}, wait, handlerType, filter, cfg)
 }
 
-// CreateForwardSessionByKey creates a new ForwardSession for the given StreamKey
+// CreateForwardSessionByKey creates a new ForwardSession for the given StreamKey.
+// If the stream has not been seen yet, the session is attached when its first packet
+// arrives, or closed if cfg["wait_timeout"] seconds or the configured default pass first.
 func (fsm *ForwardSessionManager) CreateForwardSessionByKey(key StreamKey, handlerType string, filter string, cfg map[string]any) (ForwardSessionChannel, error) {
-	return fsm.createForwardSessionImpl(key, "", handlerType, filter, cfg)
+	return fsm.createForwardSessionImpl([]StreamSelector{internal.KeySelector(key)}, true, handlerType, filter, cfg)
 }
 
-// Common code used by both CreateForwardSessionByStreamInfoID and CreateForwardSessionByKey
-// to actually create and register the ForwardSession
-func (fsm *ForwardSessionManager) createForwardSessionImpl(key StreamKey, streamInfoID string, handlerType string, filter string, cfg map[string]any) (ForwardSessionChannel, error) {
+// CreateForwardSessionByStreams creates a new ForwardSession for several streams, each given
+// as a stream ID, a stream key, all sessions of an exporter ("10.1.2.3/*") or all streams ("*").
+// The session is attached to matching streams as they appear, and closed if none has
+// appeared after the wait timeout, see CreateForwardSessionByKey.
+func (fsm *ForwardSessionManager) CreateForwardSessionByStreams(streams []string, handlerType string, filter string, cfg map[string]any) (ForwardSessionChannel, error) {
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("%w: no streams given", ErrStreamNotFound)
+	}
+	sels := make([]StreamSelector, 0, len(streams))
+	for _, str := range streams {
+		sel, err := internal.ParseStreamSelector(str)
+		if err != nil {
+			_, key := fsm.GetStreamByID(str)
+			if key == NullStreamKey {
+				return nil, fmt.Errorf("%w: %s", ErrStreamNotFound, str)
+			}
+			sel = internal.KeySelector(key)
+		}
+		if !slices.Contains(sels, sel) {
+			sels = append(sels, sel)
+		}
+	}
+	return fsm.createForwardSessionImpl(sels, true, handlerType, filter, cfg)
+}
+
+// Common code used by the CreateForwardSession functions to actually create and register
+// the ForwardSession. If wait is set the session waits for streams that do not exist yet.
+func (fsm *ForwardSessionManager) createForwardSessionImpl(sels []StreamSelector, wait bool, handlerType string, filter string, cfg map[string]any) (ForwardSessionChannel, error) {
 	factory, ok := ForwardSessionTypes[handlerType]
 	if !ok {
-		return nil, fmt.Errorf("unknown forward session type: %s", handlerType)
+		return nil, fmt.Errorf("%w: %s", ErrUnknownType, handlerType)
 	}
 	if factory == nil {
 		panic("factory function is nil for registered forward session type: " + handlerType)
 	}
-	fs, err := factory(fsm, key, streamInfoID, handlerType, filter, cfg)
+	if wait {
+		for _, sel := range sels {
+			if !sel.IsKey() {
+				continue
+			}
+			// Registered streams, such as virtual ones, are allowed
+			if reason := fsm.access.check(sel.Key); reason != "" && fsm.streams.get(sel.Key) == nil {
+				return nil, fmt.Errorf("%w (%s): %s", ErrStreamNotAllowed, reason, sel.Key)
+			}
+		}
+	}
+	multi := len(sels) > 1 || !sels[0].IsKey()
+	key, streamID := sels[0].Key, sels[0].Key.ID()
+	if multi {
+		names := make([]string, len(sels))
+		for i, sel := range sels {
+			names[i] = sel.String()
+		}
+		streamID = strings.Join(names, ",")
+	}
+	fs, err := factory(fsm, key, streamID, handlerType, filter, cfg)
 	if err != nil {
 		fsm.logger.Error("Failed to create forward session", "error", err)
 		return nil, err
 	}
+	if multi {
+		fs.SetStreamSelectors(sels)
+	}
 
-	si, exists := fsm.GetStream(fs.GetStreamKey())
-	if !exists {
-		// TODO: pending stream functionality
-		return nil, fmt.Errorf("stream not found: %s", fs.GetStreamKey())
+	fsm.sessionMu.Lock()
+	defer fsm.sessionMu.Unlock()
+	e := &sessionEntry{fs: fs, selectors: sels}
+	for _, sel := range sels {
+		if sel.IsKey() {
+			if s := fsm.streams.get(sel.Key); s != nil {
+				fsm.attach(e, s)
+			}
+			continue
+		}
+		for _, s := range fsm.streams.all() {
+			if sel.Matches(s.key) {
+				fsm.attach(e, s)
+			}
+		}
 	}
-	fsm.Lock()
-	defer fsm.Unlock()
-	si.ForwardSessions[fs] = struct{}{}
-	fsm.Streams[fs.GetStreamKey()] = si
-	fsm.logger.Debug("Created new forward session", "stream_id", si.ID, "type", handlerType, "fs", fs)
+	if !wait && len(e.streams) == 0 {
+		// expired in the meantime
+		return nil, fmt.Errorf("%w: %s", ErrStreamNotFound, streamID)
+	}
+	timeout := fsm.waitTimeout(cfg)
+	fsm.addPending(e, timeout)
+	fsm.sessions[fs] = e
+	fsm.logger.Debug("Created new forward session", "stream_info_id", streamID, "type", handlerType, "streams", len(e.streams), "timeout", timeout, "fs", fs)
 	return fs, nil
 }
 
 // DeleteForwardSession removes a ForwardSession from the manager and cleans up
 func (fsm *ForwardSessionManager) DeleteForwardSession(fs ForwardSessionChannel) {
-	si, exists := fsm.GetStream(fs.GetStreamKey())
-	fsm.Lock()
-	if exists {
-		delete(si.ForwardSessions, fs)
+	var streams []*stream
+	fsm.sessionMu.Lock()
+	if e := fsm.sessions[fs]; e != nil {
+		delete(fsm.sessions, fs)
+		fsm.removePending(e)
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		for _, s := range e.streams {
+			s.removeSession(fs)
+		}
+		streams = slices.Concat(e.streams, e.expired)
+	}
+	fsm.sessionMu.Unlock()
+	for _, s := range streams {
+		// Wait for messages being queued with the old session list
+		s.sendMu.Lock()
+		s.sendMu.Unlock()
 	}
-	fsm.Unlock()
 	// Close the channel to signal the receiver to stop
 	close(fs.GetChannel())
-	fsm.logger.Debug("Deleted forward session", "stream_id", si.ID, "fs", fs)
+	fsm.logger.Debug("Deleted forward session", "stream_info_id", fs.GetStreamInfoID(), "fs", fs)
+}
+
+// GetSessionStreams returns the keys of the streams a session is attached to
+func (fsm *ForwardSessionManager) GetSessionStreams(fs ForwardSessionChannel) []StreamKey {
+	fsm.sessionMu.Lock()
+	defer fsm.sessionMu.Unlock()
+	e := fsm.sessions[fs]
+	if e == nil {
+		return nil
+	}
+	keys := make([]StreamKey, len(e.streams))
+	for i, s := range e.streams {
+		keys[i] = s.key
+	}
+	return keys
 }
 
 func (fsm *ForwardSessionManager) GetAllForwardSessions() ForwardSessionSet {
-	fsm.RLock()
-	defer fsm.RUnlock()
 	sessions := make(ForwardSessionSet)
-	for _, si := range fsm.Streams {
-		for fs := range si.ForwardSessions {
-			sessions[fs] = struct{}{}
-		}
+	fsm.sessionMu.Lock()
+	for fs := range fsm.sessions {
+		sessions[fs] = struct{}{}
 	}
+	fsm.sessionMu.Unlock()
 	return sessions
 }
 
 func (fsm *ForwardSessionManager) CloseAllForwardSessions(msgType internal.ForwardSessionMsgType) {
 	sessions := fsm.GetAllForwardSessions()
+	list := make([]ForwardSessionChannel, 0, len(sessions))
+	for sess := range sessions {
+		list = append(list, sess.(ForwardSessionChannel))
+	}
+	fsm.sendControl(list, msgType)
+}
+
+// sendControl sends a close or shutdown message to forward sessions and waits until they
+// have been queued, or the sessions have been given up on
+func (fsm *ForwardSessionManager) sendControl(sessions []ForwardSessionChannel, msgType internal.ForwardSessionMsgType) {
 	wg := sync.WaitGroup{}
 	msg := ForwardSessionMsg{
 		Type: msgType,
 	}
 
-	for sess := range sessions {
+	for _, sess := range sessions {
 		wg.Add(1)
 		go func(ch chan ForwardSessionMsg) {
 			defer wg.Done()
@@ -120,7 +242,7 @@ func (fsm *ForwardSessionManager) CloseAllForwardSessions(msgType internal.Forwa
 			case <-time.After(1000 * time.Millisecond):
 				fsm.logger.Warn("Timeout sending close message to forward session", "fs", sess)
 			}
-		}(sess.(ForwardSessionChannel).GetChannel())
+		}(sess.GetChannel())
 	}
 	wg.Wait()
 }
@@ -0,0 +1,90 @@
+package forward
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+func init() {
+	RegisterForwardSessionType("test", NewForwardSessionBaseFactory)
+}
+
+func TestCreateForwardSessionByStreamInfoID(t *testing.T) {
+	fsm := newTestManager(&Config{QueueLength: 1})
+	fsm.updateStream(&PacketInfo{Key: testKey, Timestamp: time.Now()}, 100)
+	missing := testKey
+	missing.ErspanID++
+	wait := map[string]any{"wait_timeout": float64(1)}
+
+	tests := []struct {
+		id   string
+		cfg  map[string]any
+		want error
+	}{
+		{testKey.ID(), nil, nil},
+		{testKey.String(), nil, nil},
+		{missing.ID(), nil, ErrStreamNotFound},
+		{missing.String(), nil, ErrStreamNotFound},
+		{missing.String(), wait, nil},
+		{"*", nil, ErrStreamNotFound},
+		{"*", wait, ErrStreamNotFound},
+		{"10.1.2.3/*", wait, ErrStreamNotFound},
+		{"no such stream", nil, ErrStreamNotFound},
+	}
+	for _, tt := range tests {
+		fs, err := fsm.CreateForwardSessionByStreamInfoID(tt.id, "test", "", tt.cfg)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%q: %v, want %v", tt.id, err, tt.want)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := fsm.GetSessionStreams(fs); tt.cfg == nil && (len(got) != 1 || got[0] != testKey) {
+			t.Errorf("%q: attached to %v, want %v", tt.id, got, testKey)
+		}
+		if sels := fs.GetStreamSelectors(); len(sels) != 1 || !sels[0].IsKey() {
+			t.Errorf("%q: selectors %v, want one key", tt.id, sels)
+		}
+		fsm.DeleteForwardSession(fs)
+	}
+}
+
+func TestPendingTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	fsm := newTestManager(&Config{QueueLength: 1, PendingTimeout: timeout})
+	missing, late := testKey, testKey
+	missing.ErspanID++
+	late.ErspanID += 2
+
+	fs, err := fsm.CreateForwardSessionByKey(missing, "test", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-fs.GetChannel():
+		if msg.Type != internal.ForwardSessionMsgTypeClose {
+			t.Errorf("got message type %v, want close", msg.Type)
+		}
+	case <-time.After(10 * timeout):
+		t.Error("session waiting for a missing stream was not closed")
+	}
+	fsm.DeleteForwardSession(fs)
+
+	fs, err = fsm.CreateForwardSessionByKey(late, "test", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm.updateStream(&PacketInfo{Key: late, Timestamp: time.Now()}, 100)
+	time.Sleep(2 * timeout)
+	if got := fsm.GetSessionStreams(fs); len(got) != 1 || got[0] != late {
+		t.Errorf("attached to %v, want %v", got, late)
+	}
+	if n := len(fs.GetChannel()); n != 0 {
+		t.Errorf("%d messages queued for an attached session", n)
+	}
+	fsm.DeleteForwardSession(fs)
+}
@@ -12,12 +12,15 @@ import (
 type StreamKey = internal.StreamKey
 type StreamInfo = internal.StreamInfo
 type ForwardSessionSet = internal.ForwardSessionSet
+type IDRange = internal.IDRange
+type StreamSelector = internal.StreamSelector
 
 type ForwardSessionStats struct {
 	StartTime       int64         `json:"start_time"`
 	TotalPackets    atomic.Uint64 `json:"total_packets"`
 	FilteredPackets atomic.Uint64 `json:"filtered_packets"`
-	// number of packets in the session is TotalPackets - FilteredPackets
+	DroppedPackets  atomic.Uint64 `json:"dropped_packets"`
+	// number of packets in the session is TotalPackets - FilteredPackets - DroppedPackets
 }
 
 type ForwardSessionBase struct { // implements ForwardSession
@@ -27,12 +30,15 @@ type ForwardSessionBase struct { // implements ForwardSession
 	Filter       *pcap.BPF              `json:"-"`
 	Channel      chan ForwardSessionMsg `json:"-"`
 	Stats        *ForwardSessionStats   `json:"stats"`
+	Selectors    []StreamSelector       `json:"-"` // streams of a multi-stream session, empty for StreamKey only
 }
 
 type ForwardSessionChannel interface {
 	GetBpfFilter() *pcap.BPF
 	GetChannel() chan ForwardSessionMsg
 	GetStats() *ForwardSessionStats
+	GetStreamSelectors() []StreamSelector
+	SetStreamSelectors(selectors []StreamSelector)
 	internal.ForwardSession
 }
 
@@ -53,6 +59,7 @@ func (fs *ForwardSessionBase) GetStatsMap() *map[string]any {
 		"start_time":       fs.Stats.StartTime,
 		"total_packets":    fs.Stats.TotalPackets.Load(),
 		"filtered_packets": fs.Stats.FilteredPackets.Load(),
+		"dropped_packets":  fs.Stats.DroppedPackets.Load(),
 	}
 }
 
@@ -60,6 +67,18 @@ func (fs *ForwardSessionBase) GetStreamKey() StreamKey {
 	return fs.StreamKey
 }
 
+// GetStreamSelectors returns the streams the session subscribes to
+func (fs *ForwardSessionBase) GetStreamSelectors() []StreamSelector {
+	if len(fs.Selectors) == 0 {
+		return []StreamSelector{internal.KeySelector(fs.StreamKey)}
+	}
+	return fs.Selectors
+}
+
+func (fs *ForwardSessionBase) SetStreamSelectors(selectors []StreamSelector) {
+	fs.Selectors = selectors
+}
+
 func (fs *ForwardSessionBase) GetStreamInfoID() string {
 	return fs.StreamInfoID
 }
@@ -86,21 +105,35 @@ type forwardSessionInfo struct {
 	StreamInfoID string            `json:"stream_info_id"`
 	Type         string            `json:"type"`
 	Filter       string            `json:"filter"`
+	Streams      []string          `json:"streams,omitempty"`
 	Info         map[string]string `json:"info,omitempty"`
 	Stats        *map[string]any   `json:"stats,omitempty"`
 }
 
 func MarshalJSONIntf(fs internal.ForwardSession) ([]byte, error) {
+	var streams []string
+	if fsc, ok := fs.(ForwardSessionChannel); ok && IsMultiStream(fsc) {
+		for _, sel := range fsc.GetStreamSelectors() {
+			streams = append(streams, sel.String())
+		}
+	}
 	return json.Marshal(forwardSessionInfo{
 		StreamKey:    fs.GetStreamKey(),
 		StreamInfoID: fs.GetStreamInfoID(),
 		Type:         fs.GetType(),
 		Filter:       fs.GetFilterString(),
+		Streams:      streams,
 		Info:         fs.GetInfo(),
 		Stats:        fs.GetStatsMap(),
 	})
 }
 
+// IsMultiStream reports whether a session subscribes to more than a single stream key
+func IsMultiStream(fs ForwardSessionChannel) bool {
+	sels := fs.GetStreamSelectors()
+	return len(sels) > 1 || !sels[0].IsKey()
+}
+
 // This method is needed to satisfy the interface and must be implemented by all interfaces
 // derived from ForwardSessionBase to ensure overrides are correctly called.
 func (fs *ForwardSessionBase) MarshalJSON() ([]byte, error) {
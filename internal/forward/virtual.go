@@ -0,0 +1,225 @@
+package forward
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+// packets held by a virtual stream before the oldest is sent regardless of the jitter time
+const mergeMaxPackets = 65536
+
+// VirtualStream merges the packets of its member streams into one stream in timestamp order
+type VirtualStream struct {
+	Name    string
+	Members []StreamSelector
+}
+
+// ParseVirtualStream parses a virtual stream in the form name=member+member, where each
+// member is a stream key or all sessions of an exporter ("10.1.2.3/*")
+func ParseVirtualStream(s string) (VirtualStream, error) {
+	name, members, ok := strings.Cut(s, "=")
+	if !ok || name == "" || members == "" {
+		return VirtualStream{}, fmt.Errorf("invalid virtual stream %q: expected name=stream+stream", s)
+	}
+	if _, err := internal.ParseStreamSelector(name); err == nil {
+		return VirtualStream{}, fmt.Errorf("invalid virtual stream %q: name is a stream key", s)
+	}
+	vs := VirtualStream{Name: name}
+	for _, member := range strings.Split(members, "+") {
+		sel, err := internal.ParseStreamSelector(member)
+		if err != nil {
+			return VirtualStream{}, fmt.Errorf("invalid virtual stream %q: %w", s, err)
+		}
+		if sel.All || sel.Key.Encap == internal.EncapVirtual {
+			return VirtualStream{}, fmt.Errorf("invalid virtual stream %q: %s cannot be merged", s, member)
+		}
+		vs.Members = append(vs.Members, sel)
+	}
+	return vs, nil
+}
+
+// ParseVirtualStreams parses a list of virtual streams, see ParseVirtualStream
+func ParseVirtualStreams(list []string) ([]VirtualStream, error) {
+	streams := make([]VirtualStream, 0, len(list))
+	keys := make(map[StreamKey]string)
+	for _, s := range list {
+		vs, err := ParseVirtualStream(s)
+		if err != nil {
+			return nil, err
+		}
+		key := internal.VirtualStreamKey(vs.Name)
+		if other, dup := keys[key]; dup {
+			if other == vs.Name {
+				return nil, fmt.Errorf("duplicate virtual stream %q", vs.Name)
+			}
+			return nil, fmt.Errorf("virtual streams %q and %q have the same key %s", other, vs.Name, key)
+		}
+		keys[key] = vs.Name
+		streams = append(streams, vs)
+	}
+	return streams, nil
+}
+
+// mergeItem is a packet held by a virtual stream
+type mergeItem struct {
+	pi      PacketInfo
+	pb      *internal.PacketBuffer
+	arrival time.Time
+}
+
+// mergeQueue is a min-heap of held packets by timestamp
+type mergeQueue []*mergeItem
+
+func (q mergeQueue) Len() int           { return len(q) }
+func (q mergeQueue) Less(i, j int) bool { return q[i].pi.Timestamp.Before(q[j].pi.Timestamp) }
+func (q mergeQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *mergeQueue) Push(x any)        { *q = append(*q, x.(*mergeItem)) }
+func (q *mergeQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return item
+}
+
+// merger feeds the packets of the member streams of a virtual stream to it in timestamp
+// order. Each packet is held for the jitter time, so that a packet of another member
+// with an earlier timestamp that arrives a little later can overtake it.
+type merger struct {
+	fsm    *ForwardSessionManager
+	config VirtualStream
+	vs     *stream
+	jitter time.Duration
+
+	mu    sync.Mutex
+	queue mergeQueue
+	wake  chan struct{} // a packet became the next one due
+}
+
+// newVirtualStream registers a virtual stream and returns its merger
+func (fsm *ForwardSessionManager) newVirtualStream(cfg VirtualStream) *merger {
+	key := internal.VirtualStreamKey(cfg.Name)
+	m := &merger{
+		fsm:    fsm,
+		config: cfg,
+		jitter: fsm.config.MergeJitter,
+		wake:   make(chan struct{}, 1),
+	}
+	m.vs = newStream(key, time.Now())
+	m.vs.merger = m
+	fsm.streams.addVirtual(m.vs)
+	return m
+}
+
+// mergersFor returns the mergers of the virtual streams a stream is a member of
+func (fsm *ForwardSessionManager) mergersFor(key StreamKey) []*merger {
+	var list []*merger
+	for _, m := range fsm.mergers {
+		for _, sel := range m.config.Members {
+			if sel.Matches(key) {
+				list = append(list, m)
+				break
+			}
+		}
+	}
+	return list
+}
+
+// meta describes the virtual stream
+func (m *merger) meta() StreamMeta {
+	members := make([]string, len(m.config.Members))
+	for i, sel := range m.config.Members {
+		members[i] = sel.String()
+	}
+	return StreamMeta{
+		Name:        m.config.Name,
+		Description: "Merged from " + strings.Join(members, " + "),
+	}
+}
+
+// push holds a packet of a member stream. It takes its own reference to the buffer.
+func (m *merger) push(pi *PacketInfo, pb *internal.PacketBuffer) {
+	pb.Retain(1)
+	item := &mergeItem{pi: *pi, pb: pb, arrival: time.Now()}
+	m.mu.Lock()
+	heap.Push(&m.queue, item)
+	next := m.queue[0] == item || len(m.queue) > mergeMaxPackets
+	m.mu.Unlock()
+	if next {
+		select {
+		case m.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// due removes the packets that are due at now, and returns them with the time until the next one
+// is due, or 0 if no packets are held
+func (m *merger) due(now time.Time) (items []*mergeItem, wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.queue) > 0 {
+		if wait = m.queue[0].arrival.Add(m.jitter).Sub(now); wait > 0 && len(m.queue) <= mergeMaxPackets {
+			return items, wait
+		}
+		items = append(items, heap.Pop(&m.queue).(*mergeItem))
+	}
+	return items, 0
+}
+
+// run sends the held packets to the virtual stream as they become due, until done is closed
+func (m *merger) run(done <-chan struct{}) {
+	timer := time.NewTimer(m.jitter)
+	defer timer.Stop()
+	for {
+		items, wait := m.due(time.Now())
+		for _, item := range items {
+			m.forward(item)
+		}
+		var expired <-chan time.Time
+		if wait > 0 {
+			timer.Reset(wait)
+			expired = timer.C
+		}
+		select {
+		case <-expired:
+		case <-m.wake:
+		case <-done:
+			m.mu.Lock()
+			for _, item := range m.queue {
+				item.pb.Release()
+			}
+			m.queue = nil
+			m.mu.Unlock()
+			return
+		}
+	}
+}
+
+// forward accounts for a packet in the virtual stream and forwards it to its sessions
+func (m *merger) forward(item *mergeItem) {
+	pi := item.pi
+	pi.Key = m.vs.key
+	pi.HasSeq = false // sequence numbers of different members cannot be compared
+	m.vs.update(&pi, len(item.pb.Data), time.Now())
+	m.fsm.forwardToSessions(m.vs, pi.Timestamp, item.pb)
+	item.pb.Release()
+}
+
+// RunMergers merges the packets of the virtual streams until done is closed
+func (fsm *ForwardSessionManager) RunMergers(done <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, m := range fsm.mergers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.run(done)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,112 @@
+package forward
+
+import (
+	"testing"
+	"time"
+
+	"anthonyuk.dev/erspan-hub/internal"
+)
+
+// newTestMerge returns a manager merging testKey and a second stream into one virtual
+// stream, with a session on the virtual stream
+func newTestMerge(t *testing.T, jitter time.Duration) (*ForwardSessionManager, *ForwardSessionBase, StreamKey) {
+	t.Helper()
+	other := testKey
+	other.ErspanID++
+	fsm := newTestManager(&Config{
+		QueueLength:    8,
+		MergeJitter:    jitter,
+		VirtualStreams: []VirtualStream{{Name: "merged", Members: []StreamSelector{internal.KeySelector(testKey), internal.KeySelector(other)}}},
+	})
+	fs, err := fsm.CreateForwardSessionByKey(internal.VirtualStreamKey("merged"), "test", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsm, fs.(*ForwardSessionBase), other
+}
+
+func TestMergerOrder(t *testing.T) {
+	const jitter = 100 * time.Millisecond
+	fsm, fs, other := newTestMerge(t, jitter)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		fsm.RunMergers(done)
+		close(stopped)
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	// Interleaved packets of both members, each out of order with the other member
+	base := time.Now()
+	packets := []struct {
+		key    StreamKey
+		offset time.Duration
+		data   byte // position in timestamp order
+	}{
+		{testKey, 10 * time.Millisecond, '2'},
+		{other, 5 * time.Millisecond, '1'},
+		{testKey, 30 * time.Millisecond, '4'},
+		{other, 20 * time.Millisecond, '3'},
+	}
+	var pbs []*internal.PacketBuffer
+	for _, p := range packets {
+		pb := newTestBuffer(p.data)
+		pb.Retain(1) // keep a reference to check the buffer afterwards
+		pbs = append(pbs, pb)
+		fsm.ProcessPacket(&PacketInfo{Key: p.key, Timestamp: base.Add(p.offset)}, pb)
+	}
+
+	time.Sleep(jitter / 2)
+	if got := queued(fs); len(got) != 0 {
+		t.Fatalf("forwarded %q before the jitter time", got)
+	}
+	time.Sleep(jitter * 3 / 2)
+	if got := queued(fs); string(got) != "1234" {
+		t.Errorf("forwarded %q, want %q", got, "1234")
+	}
+	for i, pb := range pbs {
+		if refs := pb.Refs(); refs != 1 {
+			t.Errorf("packet %d: %d references after forwarding, want 1", i, refs)
+		}
+		pb.Release()
+	}
+	si, _ := fsm.GetStreamByID("merged")
+	if si == nil || si.Packets != uint64(len(packets)) {
+		t.Errorf("virtual stream %+v, want %d packets", si, len(packets))
+	}
+}
+
+func TestMergerShutdown(t *testing.T) {
+	fsm, fs, other := newTestMerge(t, time.Hour)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		fsm.RunMergers(done)
+		close(stopped)
+	}()
+
+	var pbs []*internal.PacketBuffer
+	for i, key := range []StreamKey{testKey, other, testKey} {
+		pb := newTestBuffer(byte('a' + i))
+		pb.Retain(1)
+		pbs = append(pbs, pb)
+		fsm.ProcessPacket(&PacketInfo{Key: key, Timestamp: time.Now()}, pb)
+	}
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("mergers did not stop")
+	}
+	if got := queued(fs); len(got) != 0 {
+		t.Errorf("forwarded %q held packets on shutdown", got)
+	}
+	for i, pb := range pbs {
+		if refs := pb.Refs(); refs != 1 {
+			t.Errorf("packet %d: %d references after shutdown, want 1", i, refs)
+		}
+	}
+}
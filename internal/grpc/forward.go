@@ -2,7 +2,10 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/netip"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,7 +16,9 @@ import (
 
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 type ForwardSessionGrpc struct {
@@ -102,26 +107,63 @@ func (s *PcapForwarderServer) ForwardStream(req *pcap_v1.ForwardRequest, svr pca
 		cfg["peer"] = p
 	}
 
-	fs_, err := s.gsvr.fsm.CreateForwardSessionByStreamInfoID(
-		streamInfoID,
-		"grpc_pcap", filter,
-		cfg,
-	)
+	var fs_ forward.ForwardSessionChannel
+	var err error
+	hasKey := req.GetSrcIp() != "" || req.GetErspanId() != 0 || req.GetEncap() != ""
+	forms := 0
+	for _, given := range []bool{streamInfoID != "", hasKey, len(req.GetStreams()) > 0} {
+		if given {
+			forms++
+		}
+	}
+	switch {
+	case forms > 1:
+		return status.Error(codes.InvalidArgument, "give only one of stream_info_id, src_ip with erspan_id and encap, or streams")
+	case len(req.GetStreams()) > 0:
+		streamInfoID = strings.Join(req.GetStreams(), ",")
+		fs_, err = s.gsvr.fsm.CreateForwardSessionByStreams(req.GetStreams(), "grpc_pcap", filter, cfg)
+	case streamInfoID != "":
+		fs_, err = s.gsvr.fsm.CreateForwardSessionByStreamInfoID(
+			streamInfoID,
+			"grpc_pcap", filter,
+			cfg,
+		)
+	case req.GetSrcIp() == "":
+		return status.Error(codes.InvalidArgument, "stream_info_id, src_ip or streams is required")
+	default:
+		key, kerr := requestStreamKey(req)
+		if kerr != nil {
+			return status.Error(codes.InvalidArgument, kerr.Error())
+		}
+		streamInfoID = key.String()
+		fs_, err = s.gsvr.fsm.CreateForwardSessionByKey(key, "grpc_pcap", filter, cfg)
+	}
 	if err != nil {
 		s.gsvr.logger.ErrorContext(ctx, "Failed to create forward session", "error", err)
-		return err
+		return sessionStatus(err)
 	}
 	fs := fs_.(*ForwardSessionGrpc)
 	defer s.gsvr.fsm.DeleteForwardSession(fs)
 	ch := fs.GetChannel()
 
 	pfw := &PcapForwarderWriter{svr: svr}
-	pcapw, err := forward.NewPcapNgWriter(pfw, fs)
+	var pcapw *forward.PcapNgWriter
+	if forward.IsMultiStream(fs) {
+		// One interface per stream, those that appear later are added with their first packet
+		pcapw = forward.NewPcapNgStreamsWriter(pfw, fs, s.gsvr.fsm.GetStreamTimestampSource)
+		for _, key := range s.gsvr.fsm.GetSessionStreams(fs) {
+			if _, err = pcapw.AddInterface(key); err != nil {
+				break
+			}
+		}
+	} else {
+		pcapw, err = forward.NewPcapNgWriter(pfw, fs, s.gsvr.fsm.GetStreamTimestampSource(fs.GetStreamKey()))
+	}
 	if err != nil {
 		s.gsvr.logger.ErrorContext(ctx, "Failed to create pcapng writer", "error", err)
 		return err
 	}
-	defer pcapw.NgWriter.Flush()
+	defer pcapw.Flush()
 
 	// Run a goroutine to flush pcapng writer periodically
 	ticker := time.NewTicker(200 * time.Millisecond)
@@ -130,12 +172,12 @@ func (s *PcapForwarderServer) ForwardStream(req *pcap_v1.ForwardRequest, svr pca
 			select {
 			case <-ticker.C:
 				mu.Lock()
-				pcapw.NgWriter.Flush()
+				pcapw.Flush()
 				mu.Unlock()
 			case <-ctx.Done():
 				ticker.Stop()
 				mu.Lock()
-				pcapw.NgWriter.Flush()
+				pcapw.Flush()
 				mu.Unlock()
 				return
 			}
@@ -153,7 +195,9 @@ func (s *PcapForwarderServer) ForwardStream(req *pcap_v1.ForwardRequest, svr pca
 			switch msg.Type {
 			case internal.ForwardSessionMsgTypePacket:
 				mu.Lock()
-				if err := pcapw.WritePacket(msg.Packet, msg.Time); err != nil {
+				err := pcapw.WriteStreamPacket(msg.Key, msg.Buffer.Data, msg.Time)
+				msg.Buffer.Release()
+				if err != nil {
 					s.gsvr.logger.ErrorContext(ctx, "Failed to write packet via gRPC", "error", err)
 					mu.Unlock()
 					return err
@@ -162,7 +206,7 @@ func (s *PcapForwarderServer) ForwardStream(req *pcap_v1.ForwardRequest, svr pca
 				mu.Unlock()
 
 			case internal.ForwardSessionMsgTypeClose:
-				pcapw.NgWriter.Flush()
+				pcapw.Flush()
 				svr.Send(&pcap_v1.PacketBlock{
 					Timestamp: -1,
 					RawData:   nil,
@@ -170,7 +214,7 @@ func (s *PcapForwarderServer) ForwardStream(req *pcap_v1.ForwardRequest, svr pca
 				return nil
 
 			case internal.ForwardSessionMsgTypeShutdown:
-				pcapw.NgWriter.Flush()
+				pcapw.Flush()
 				svr.Send(&pcap_v1.PacketBlock{
 					Timestamp: -2,
 					RawData:   nil,
@@ -184,6 +228,32 @@ func (s *PcapForwarderServer) ForwardStream(req *pcap_v1.ForwardRequest, svr pca
 	}
 }
 
+// requestStreamKey returns the stream key given by the src_ip, erspan_id and encap of a request
+func requestStreamKey(req *pcap_v1.ForwardRequest) (internal.StreamKey, error) {
+	srcIP, err := netip.ParseAddr(req.GetSrcIp())
+	if err != nil {
+		return internal.NullStreamKey, fmt.Errorf("invalid src_ip: %w", err)
+	}
+	encap, err := internal.ParseEncap(req.GetEncap())
+	if err != nil {
+		return internal.NullStreamKey, err
+	}
+	return internal.StreamKey{SrcIP: srcIP.Unmap(), ErspanID: req.GetErspanId(), Encap: encap}, nil
+}
+
+// sessionStatus converts an error creating a forward session to a gRPC status
+func sessionStatus(err error) error {
+	switch {
+	case errors.Is(err, forward.ErrStreamNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, forward.ErrStreamNotAllowed):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, forward.ErrBadFilter), errors.Is(err, forward.ErrUnknownType):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
 type ValidateFilterServer struct {
 	gsvr *GrpcServer
 	pcap_v1.UnimplementedValidateFilterServiceServer
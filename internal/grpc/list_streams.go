@@ -4,6 +4,8 @@ import (
 	"context"
 
 	streams_v1 "anthonyuk.dev/erspan-hub/generated/streams/v1"
+	"anthonyuk.dev/erspan-hub/internal"
+	"anthonyuk.dev/erspan-hub/internal/forward"
 )
 
 type StreamsServiceServer struct {
@@ -12,31 +14,44 @@ type StreamsServiceServer struct {
 }
 
 func (s *StreamsServiceServer) ListStreams(ctx context.Context, req *streams_v1.ListStreamsRequest) (*streams_v1.ListStreamsResponse, error) {
-	s.gsvr.fsm.RLock()
-	defer s.gsvr.fsm.RUnlock()
-
 	resp := &streams_v1.ListStreamsResponse{}
-	for id, info := range s.gsvr.fsm.Streams {
+	for _, info := range s.gsvr.fsm.Search(req.GetQuery()) {
+		id := info.Key()
 		sinfo := streams_v1.StreamInfo{
 			Id:              info.ID,
-			SrcIp:           uint32(id.SrcIP.ToUint32()),
-			ErspanId:        uint32(id.ErspanID),
+			SrcIp:           internal.AddrToUint32(id.SrcIP),
+			SrcAddr:         id.SrcIP.AsSlice(),
+			ErspanId:        id.ErspanID,
+			Encap:           id.Encap.String(),
 			ErspanVersion:   uint32(info.ErspanVersion),
 			FirstSeen:       info.FirstSeen.UnixNano(),
 			LastSeen:        info.LastSeen.UnixNano(),
 			Packets:         info.Packets,
 			Bytes:           info.Bytes,
+			SeqLost:         info.SeqLost,
+			SeqDuplicate:    info.SeqDuplicate,
+			SeqOutOfOrder:   info.SeqOutOfOrder,
+			State:           string(info.State),
+			Name:            info.Name,
+			Site:            info.Site,
+			Description:     info.Description,
+			Labels:          info.Labels,
 			ForwardSessions: make([]*streams_v1.ForwardSession, 0, len(info.ForwardSessions)),
 		}
 		for fs := range info.ForwardSessions {
 			sinfo_fs := streams_v1.ForwardSession{
-				SrcIp:        uint32(fs.GetStreamKey().SrcIP.ToUint32()),
-				ErspanId:     uint32(fs.GetStreamKey().ErspanID),
+				SrcIp:        internal.AddrToUint32(fs.GetStreamKey().SrcIP),
+				SrcAddr:      fs.GetStreamKey().SrcIP.AsSlice(),
+				ErspanId:     fs.GetStreamKey().ErspanID,
+				Encap:        fs.GetStreamKey().Encap.String(),
 				StreamInfoId: fs.GetStreamInfoID(),
 				Type:         fs.GetType(),
 				Filter:       fs.GetFilterString(),
 				Info:         fs.GetInfo(),
 			}
+			if fsc, ok := fs.(forward.ForwardSessionChannel); ok {
+				sinfo_fs.DroppedPackets = fsc.GetStats().DroppedPackets.Load()
+			}
 			sinfo.ForwardSessions = append(sinfo.ForwardSessions, &sinfo_fs)
 		}
 		resp.Streams = append(resp.Streams, &sinfo)
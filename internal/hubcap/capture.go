@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	pcap_v1 "anthonyuk.dev/erspan-hub/generated/pcap/v1"
@@ -11,6 +12,18 @@ import (
 )
 
 func RunCapture(cfg *Config, logger *slog.Logger, clientInfo map[string]string) (err error) {
+	// The hub takes the streams either by ID or key, or by exporter and session ID
+	switch {
+	case len(cfg.Streams) > 0 && (cfg.SrcIP != "" || cfg.ErspanID != 0):
+		err = fmt.Errorf("--stream cannot be combined with --src-ip or --erspan-id")
+	case cfg.SrcIP == "" && cfg.ErspanID != 0:
+		err = fmt.Errorf("--erspan-id requires --src-ip")
+	}
+	if err != nil {
+		logger.Error("Invalid capture options", "error", err)
+		return err
+	}
+
 	fifo, err := os.OpenFile(cfg.Fifo, os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		logger.Error("Failed to open fifo for writing", "fifo", cfg.Fifo, "error", err)
@@ -42,9 +55,9 @@ func RunCapture(cfg *Config, logger *slog.Logger, clientInfo map[string]string)
 		defer ctrlOut.Close()
 	}
 
-	streamID := cfg.StreamID
-	if cfg.StreamID == "" {
-		resp, err := cl.StreamsClient.ListStreams(ctx, &streams_v1.ListStreamsRequest{})
+	streams := cfg.Streams
+	if len(streams) == 0 && cfg.SrcIP == "" {
+		resp, err := cl.StreamsClient.ListStreams(ctx, &streams_v1.ListStreamsRequest{Query: cfg.Search})
 		if err != nil {
 			logger.Error("could not list streams", "error", err)
 			return err
@@ -55,11 +68,18 @@ func RunCapture(cfg *Config, logger *slog.Logger, clientInfo map[string]string)
 			logger.Info("No streams available")
 			return nil
 		}
-		streamID = resp.Streams[0].Id
+		streams = []string{resp.Streams[0].Id}
+	}
+	req := &pcap_v1.ForwardRequest{SrcIp: cfg.SrcIP, ErspanId: cfg.ErspanID, Filter: cfg.Filter, ClientInfo: clientInfo}
+	if len(streams) == 1 && !strings.HasSuffix(streams[0], "*") {
+		req.StreamInfoId = streams[0]
+	} else {
+		// Each stream is a separate pcapng interface, wildcards are only accepted here
+		req.Streams = streams
 	}
-	logger.DebugContext(ctx, "Start capturing", "streamID", streamID, "fifo", cfg.Fifo, "filter", cfg.Filter)
+	logger.DebugContext(ctx, "Start capturing", "streams", streams, "src_ip", cfg.SrcIP, "erspan_id", cfg.ErspanID, "fifo", cfg.Fifo, "filter", cfg.Filter)
 
-	stream, err := cl.PcapClient.ForwardStream(ctx, &pcap_v1.ForwardRequest{StreamInfoId: streamID, Filter: cfg.Filter, ClientInfo: clientInfo})
+	stream, err := cl.PcapClient.ForwardStream(ctx, req)
 	if err != nil {
 		logger.Error("could not subscribe to stream", "error", err)
 		return err
@@ -38,7 +38,7 @@ func ListStreams(cfg *Config, logger *slog.Logger) (streams []*client.StreamInfo
 	if err != nil {
 		return nil, err
 	}
-	streams, err2 := cl.ListStreams(ctx)
+	streams, err2 := cl.ListStreams(ctx, cfg.Search)
 	if err2 != nil {
 		return nil, err2
 	}
@@ -12,31 +12,34 @@ import (
 )
 
 type Config struct {
-	ExtcapInterfaces      bool   `koanf:"extcap-interfaces"`
-	ExtcapDlts            bool   `koanf:"extcap-dlts"`
-	ExtcapInterface       string `koanf:"extcap-interface"`
-	ExtcapConfig          bool   `koanf:"extcap-config"`
-	ExtcapVersion         string `koanf:"extcap-version"`
-	ExtcapReloadOption    string `koanf:"extcap-reload-option"`
-	ExtcapControlIn       string `koanf:"extcap-control-in"`
-	ExtcapControlOut      string `koanf:"extcap-control-out"`
-	ExtcapCleanupPostkill bool   `koanf:"extcap-cleanup-postkill"`
-	Capture               bool   `koanf:"capture"`
-	StreamID              string `koanf:"stream"`
-	Filter                string `koanf:"filter"`
-	BpfDumpType           int    `koanf:"bpf-dump-type"` // 0=none, 2=C, 3=decimal
-	Fifo                  string `koanf:"fifo"`
-	GrpcUrl               string `koanf:"grpcurl"`
-	GrpcTLS               bool   `koanf:"grpc-tls"`
-	GrpcTLSInsecure       bool   `koanf:"grpc-tls-insecure"`
-	GrpcTLSCAFile         string `koanf:"grpc-tls-ca-file"`
-	GrpcTLSCA             string `koanf:"grpc-tls-ca"`
-	ListStreams           bool   `koanf:"list-streams"`
-	TestCapture           bool   `koanf:"test-capture"`
-	LogLevel              int    `koanf:"verbose"`
-	LogFile               string `koanf:"log-file"`
-	LogJson               bool   `koanf:"log-json"`
-	ShowVersion           bool   `koanf:"version"`
+	ExtcapInterfaces      bool     `koanf:"extcap-interfaces"`
+	ExtcapDlts            bool     `koanf:"extcap-dlts"`
+	ExtcapInterface       string   `koanf:"extcap-interface"`
+	ExtcapConfig          bool     `koanf:"extcap-config"`
+	ExtcapVersion         string   `koanf:"extcap-version"`
+	ExtcapReloadOption    string   `koanf:"extcap-reload-option"`
+	ExtcapControlIn       string   `koanf:"extcap-control-in"`
+	ExtcapControlOut      string   `koanf:"extcap-control-out"`
+	ExtcapCleanupPostkill bool     `koanf:"extcap-cleanup-postkill"`
+	Capture               bool     `koanf:"capture"`
+	Streams               []string `koanf:"stream"`
+	SrcIP                 string   `koanf:"src-ip"`
+	ErspanID              uint32   `koanf:"erspan-id"`
+	Filter                string   `koanf:"filter"`
+	BpfDumpType           int      `koanf:"bpf-dump-type"` // 0=none, 2=C, 3=decimal
+	Fifo                  string   `koanf:"fifo"`
+	GrpcUrl               string   `koanf:"grpcurl"`
+	GrpcTLS               bool     `koanf:"grpc-tls"`
+	GrpcTLSInsecure       bool     `koanf:"grpc-tls-insecure"`
+	GrpcTLSCAFile         string   `koanf:"grpc-tls-ca-file"`
+	GrpcTLSCA             string   `koanf:"grpc-tls-ca"`
+	ListStreams           bool     `koanf:"list-streams"`
+	Search                string   `koanf:"search"`
+	TestCapture           bool     `koanf:"test-capture"`
+	LogLevel              int      `koanf:"verbose"`
+	LogFile               string   `koanf:"log-file"`
+	LogJson               bool     `koanf:"log-json"`
+	ShowVersion           bool     `koanf:"version"`
 }
 
 func LoadConfig() (*Config, error) {
@@ -57,7 +60,9 @@ func LoadConfig() (*Config, error) {
 	fs.String("extcap-control-out", "", "Used to send control messages to toolbar")
 	fs.Bool("extcap-cleanup-postkill", false, "Cleanup after being killed (no-op)")
 	fs.Bool("capture", false, "run the capture")
-	fs.String("stream", "", "ERSPAN stream ID to capture from")
+	fs.StringSlice("stream", nil, "ERSPAN stream ID, key (e.g. 10.1.2.3/42) or wildcard (10.1.2.3/* or *) to capture from, repeat to capture several streams")
+	fs.String("src-ip", "", "Exporter IP address of the stream to capture from (instead of --stream)")
+	fs.Uint32("erspan-id", 0, "ERSPAN ID of the stream given by --src-ip")
 	fs.StringVar(fs.String("filter", "", "capture filter (BPF syntax)"), "extcap-capture-filter", "", "capture filter (BPF syntax)")
 	fs.CountP("bpf-dump-type", "d", "Dump BPF instructions (-dd=C, -ddd=decimal)")
 	fs.String("fifo", "", "dump data to file or fifo")
@@ -67,6 +72,7 @@ func LoadConfig() (*Config, error) {
 	fs.BoolP("grpc-tls-insecure", "k", false, "Skip gRPC TLS certificate verification")
 	fs.String("grpc-tls-ca-file", "", "CA file for gRPC TLS connection (uses system CAs if empty)")
 	fs.BoolP("list-streams", "l", false, "List available streams")
+	fs.String("search", "", "Only list streams matching these words (name, site, description, key or label=value)")
 	fs.Bool("test-capture", false, "Test capture (subscribe to first stream and discard packets)")
 	fs.BoolP("log-json", "j", false, "Enable JSON formatted logs")
 	fs.StringVar(&logLevel, "log-level", "", "Log level (warn, info, debug)")
@@ -20,7 +20,53 @@ func ExtcapListStreams(cfg *Config, logger *slog.Logger) error {
 		return nil
 	}
 	for _, stream := range streams {
-		fmt.Printf("value {arg=4}{value=%s}{display=%s, session %d}\n", stream.ID, stream.SrcIP, stream.ErspanID)
+		if stream.Encap == "virtual" {
+			fmt.Printf("value {arg=4}{value=%s}{display=%s - virtual stream}\n", stream.ID, stream.Name)
+			continue
+		}
+		session := fmt.Sprintf("session %d", stream.ErspanID)
+		if stream.Encap != "" && stream.Encap != "erspan" {
+			session = fmt.Sprintf("%s %d", stream.Encap, stream.ErspanID)
+		}
+		display := fmt.Sprintf("%s, %s", stream.SrcIP, session)
+		if stream.Name != "" {
+			name := stream.Name
+			if stream.Site != "" {
+				name = fmt.Sprintf("%s (%s)", name, stream.Site)
+			}
+			display = fmt.Sprintf("%s - %s", name, display)
+		}
+		fmt.Printf("value {arg=4}{value=%s}{display=%s}\n", stream.ID, display)
+	}
+	if cfg.Search != "" {
+		// Wildcards would not be limited to the streams found
+		return nil
+	}
+	// Wildcards capture several streams, each as its own interface
+	var exporters []string
+	count := make(map[string]int)
+	captured := 0
+	for _, stream := range streams {
+		if stream.Encap == "virtual" {
+			continue
+		}
+		captured++
+		exporter := stream.SrcIP.String()
+		if stream.Encap != "" && stream.Encap != "erspan" {
+			exporter = stream.Encap + ":" + exporter
+		}
+		if count[exporter] == 0 {
+			exporters = append(exporters, exporter)
+		}
+		count[exporter]++
+	}
+	for _, exporter := range exporters {
+		if count[exporter] > 1 {
+			fmt.Printf("value {arg=4}{value=%s/*}{display=%s, all %d sessions}\n", exporter, exporter, count[exporter])
+		}
+	}
+	if captured > 1 {
+		fmt.Printf("value {arg=4}{value=*}{display=All streams}\n")
 	}
 	return nil
 }
@@ -43,6 +89,7 @@ func HandleExtcapOptions(cfg *Config, logger *slog.Logger) (handled bool, err er
 		fmt.Printf("arg {number=1}{call=--grpc-tls}{type=boolflag}{display=gRPC TLS}{tooltip=Enable TLS on gRPC connection}\n")
 		fmt.Printf("arg {number=2}{call=--grpc-tls-insecure}{type=boolflag}{display=Insecure gRPC TLS}{tooltip=Skip TLS certificate verification}\n")
 		fmt.Printf("arg {number=3}{call=--grpc-tls-ca-file}{type=fileselect}{display=gRPC TLS CA File}{tooltip=Path to the gRPC TLS CA file}\n")
+		fmt.Printf("arg {number=5}{call=--search}{type=string}{display=Stream search}{tooltip=Only offer streams matching these words, e.g. a name, site or label=value (reload the stream list after changing)}{required=false}\n")
 		fmt.Printf(`arg {number=9}{call=--log-level}{display=Set the log level}{type=selector}{tooltip=Set the log level}{required=false}{group=Debug}
 value {arg=2}{value=warn}{display=Warnings}{default=true}
 value {arg=2}{value=info}{display=Info}
@@ -17,13 +17,17 @@ func HandleListStreams(cfg *Config, logger *slog.Logger) (err error) {
 	}
 	fmt.Printf("Available streams:\n")
 	for _, stream := range streams {
-		fmt.Printf("ID: %s, SrcIP: %s, ERSPAN ID: %d, Version: %d, FirstSeen: %s, LastSeen: %s, Packets: %d, Bytes: %d\n",
-			stream.ID, stream.SrcIP, stream.ErspanID, stream.ErspanVersion, stream.FirstSeen, stream.LastSeen, stream.Packets, stream.Bytes)
+		fmt.Printf("ID: %s, State: %s, Encap: %s, SrcIP: %s, ERSPAN ID: %d, Version: %d, FirstSeen: %s, LastSeen: %s, Packets: %d, Bytes: %d, Lost: %d, Duplicate: %d, Out of order: %d\n",
+			stream.ID, stream.State, stream.Encap, stream.SrcIP, stream.ErspanID, stream.ErspanVersion, stream.FirstSeen, stream.LastSeen, stream.Packets, stream.Bytes,
+			stream.SeqLost, stream.SeqDuplicate, stream.SeqOutOfOrder)
+		if stream.Name != "" || stream.Site != "" || stream.Description != "" || len(stream.Labels) > 0 {
+			fmt.Printf("  Name: %s, Site: %s, Description: %s, Labels: %v\n", stream.Name, stream.Site, stream.Description, stream.Labels)
+		}
 		if len(stream.ForwardSessions) > 0 {
 			fmt.Printf("  Forward Sessions:\n")
 			for _, sess := range stream.ForwardSessions {
-				fmt.Printf("    SrcIP: %s, ERSPAN ID: %d, StreamInfoID: %s, Type: %s, Filter: %s, Info: %v\n",
-					sess.SrcIP, sess.ErspanID, sess.StreamInfoID, sess.Type, sess.Filter, sess.Info)
+				fmt.Printf("    SrcIP: %s, ERSPAN ID: %d, StreamInfoID: %s, Type: %s, Filter: %s, Dropped: %d, Info: %v\n",
+					sess.SrcIP, sess.ErspanID, sess.StreamInfoID, sess.Type, sess.Filter, sess.DroppedPackets, sess.Info)
 
 			}
 		}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IDRange is an inclusive range of session IDs
+type IDRange struct {
+	First uint32 `json:"first"`
+	Last  uint32 `json:"last"`
+}
+
+// ParseIDRange parses a single ID ("42") or a range ("100-199")
+func ParseIDRange(s string) (IDRange, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	f, err := strconv.ParseUint(strings.TrimSpace(first), 10, 32)
+	if err != nil {
+		return IDRange{}, fmt.Errorf("invalid ID range %q: %w", s, err)
+	}
+	r := IDRange{First: uint32(f), Last: uint32(f)}
+	if isRange {
+		l, err := strconv.ParseUint(strings.TrimSpace(last), 10, 32)
+		if err != nil {
+			return IDRange{}, fmt.Errorf("invalid ID range %q: %w", s, err)
+		}
+		r.Last = uint32(l)
+	}
+	if r.Last < r.First {
+		return IDRange{}, fmt.Errorf("invalid ID range %q: end is before start", s)
+	}
+	return r, nil
+}
+
+// ParseIDRanges parses a list of ID ranges
+func ParseIDRanges(list []string) ([]IDRange, error) {
+	ranges := make([]IDRange, 0, len(list))
+	for _, s := range list {
+		r, err := ParseIDRange(s)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func (r IDRange) Contains(id uint32) bool {
+	return id >= r.First && id <= r.Last
+}
+
+func (r IDRange) String() string {
+	if r.First == r.Last {
+		return strconv.FormatUint(uint64(r.First), 10)
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Capacities of the packet buffer pools, a packet uses the smallest that fits
+var packetBufferSizes = [...]int{2048, 16384, 65536}
+
+var packetBufferPools [len(packetBufferSizes)]sync.Pool
+
+func init() {
+	for i, size := range packetBufferSizes {
+		packetBufferPools[i].New = func() any {
+			return &PacketBuffer{buf: make([]byte, size), pool: i}
+		}
+	}
+}
+
+// PacketBuffer is a pooled, reference counted copy of a captured packet that is
+// shared by all sessions it is forwarded to. Every holder of a reference calls
+// Release once it no longer reads Data, and the buffer goes back to its pool
+// when the last reference is released.
+type PacketBuffer struct {
+	Data []byte
+	buf  []byte
+	pool int
+	refs atomic.Int32
+}
+
+// NewPacketBuffer copies data into a pooled buffer, holding one reference.
+// Packets larger than the largest pool get a buffer of their own.
+func NewPacketBuffer(data []byte) *PacketBuffer {
+	for i, size := range packetBufferSizes {
+		if len(data) <= size {
+			pb := packetBufferPools[i].Get().(*PacketBuffer)
+			pb.Data = pb.buf[:copy(pb.buf, data)]
+			pb.refs.Store(1)
+			return pb
+		}
+	}
+	pb := &PacketBuffer{buf: append([]byte(nil), data...), pool: -1}
+	pb.Data = pb.buf
+	pb.refs.Store(1)
+	return pb
+}
+
+// Refs returns the number of references held
+func (pb *PacketBuffer) Refs() int {
+	return int(pb.refs.Load())
+}
+
+// Retain adds n references to the buffer
+func (pb *PacketBuffer) Retain(n int) {
+	pb.refs.Add(int32(n))
+}
+
+// Release drops a reference to the buffer. It is a no-op on a nil buffer so that
+// messages without a packet can be released unconditionally.
+func (pb *PacketBuffer) Release() {
+	if pb == nil {
+		return
+	}
+	switch refs := pb.refs.Add(-1); {
+	case refs > 0:
+		return
+	case refs < 0:
+		panic("packet buffer released more often than retained")
+	}
+	pb.Data = nil
+	if pb.pool >= 0 {
+		packetBufferPools[pb.pool].Put(pb)
+	}
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestPacketBufferSizes(t *testing.T) {
+	tests := []struct {
+		len  int
+		cap  int // of the pooled buffer, 0 for a buffer of its own
+		pool int
+	}{
+		{0, 2048, 0},
+		{2048, 2048, 0},
+		{2049, 16384, 1},
+		{65536, 65536, 2},
+		{65537, 0, -1},
+	}
+	for _, tt := range tests {
+		data := bytes.Repeat([]byte{1}, tt.len)
+		pb := NewPacketBuffer(data)
+		if !bytes.Equal(pb.Data, data) || tt.cap != 0 && cap(pb.buf) != tt.cap || pb.pool != tt.pool || pb.Refs() != 1 {
+			t.Errorf("%d bytes: capacity %d, pool %d, %d references, want %d, %d, 1",
+				tt.len, cap(pb.buf), pb.pool, pb.Refs(), tt.cap, tt.pool)
+		}
+		pb.Release()
+	}
+}
+
+func TestPacketBufferReleaseConcurrent(t *testing.T) {
+	const sessions = 64
+	for _, size := range []int{100, 70000} {
+		pb := NewPacketBuffer(make([]byte, size))
+		pb.Retain(sessions)
+		var wg sync.WaitGroup
+		for range sessions {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if len(pb.Data) != size {
+					t.Errorf("%d bytes: data released while referenced", size)
+				}
+				pb.Release()
+			}()
+		}
+		wg.Wait()
+		if pb.Refs() != 1 || len(pb.Data) != size {
+			t.Fatalf("%d bytes: %d references, want 1", size, pb.Refs())
+		}
+		// The last release frees the buffer, an extra one is a bug
+		pb.Release()
+		if pb.Refs() != 0 || pb.Data != nil {
+			t.Errorf("%d bytes: %d references after the last release", size, pb.Refs())
+		}
+		if size > packetBufferSizes[len(packetBufferSizes)-1] {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%d bytes: releasing a free buffer did not panic", size)
+					}
+				}()
+				pb.Release()
+			}()
+		}
+	}
+}
+
+func TestPacketBufferReuse(t *testing.T) {
+	// sync.Pool may drop buffers, the race detector does so on purpose, so try a few times
+	for range 100 {
+		pb := NewPacketBuffer([]byte("first packet"))
+		pb.Release()
+		next := NewPacketBuffer([]byte("next"))
+		if next != pb {
+			next.Release()
+			continue
+		}
+		if string(next.Data) != "next" || next.Refs() != 1 {
+			t.Errorf("reused buffer holds %q with %d references", next.Data, next.Refs())
+		}
+		next.Release()
+		return
+	}
+	t.Error("released buffers were never reused")
+}
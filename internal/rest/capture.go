@@ -0,0 +1,11 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// socketFiltersHandler lists the BPF programs attached to the capture sockets
+func (rsvr *RestServer) socketFiltersHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(rsvr.capture.SocketFilters())
+}
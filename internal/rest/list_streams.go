@@ -9,15 +9,13 @@ import (
 )
 
 func (rsvr *RestServer) listStreamsHandler(w http.ResponseWriter, r *http.Request) {
-	rsvr.fsm.RLock()
-	defer rsvr.fsm.RUnlock()
 	type out struct {
 		ID         string              `json:"id"`
 		StreamInfo *forward.StreamInfo `json:"stream_info"`
 	}
 	var list []out
-	for k := range rsvr.fsm.Streams {
-		list = append(list, out{k.String(), rsvr.fsm.Streams[k]})
+	for _, si := range rsvr.fsm.Search(r.URL.Query().Get("q")) {
+		list = append(list, out{si.Key().String(), si})
 	}
 	json.NewEncoder(w).Encode(list)
 }
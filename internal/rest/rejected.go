@@ -0,0 +1,11 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// rejectedHandler lists the exporters whose traffic was not accepted into the stream registry
+func (rsvr *RestServer) rejectedHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(rsvr.fsm.RejectedSources())
+}
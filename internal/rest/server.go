@@ -7,12 +7,14 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/pprof"
+	"net/netip"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"anthonyuk.dev/erspan-hub/internal"
+	"anthonyuk.dev/erspan-hub/internal/capture"
 	"anthonyuk.dev/erspan-hub/internal/forward"
 
 	"github.com/go-chi/chi/v5"
@@ -22,12 +24,13 @@ import (
 )
 
 type RestServer struct {
-	logger *slog.Logger
-	config *Config
-	fsm    *forward.ForwardSessionManager
+	logger  *slog.Logger
+	config  *Config
+	fsm     *forward.ForwardSessionManager
+	capture *capture.CaptureInstance
 }
 
-func RunServer(cfg *Config, fsm *forward.ForwardSessionManager) error {
+func RunServer(cfg *Config, fsm *forward.ForwardSessionManager, ci *capture.CaptureInstance) error {
 
 	r := chi.NewRouter()
 	r.Use(middleware.RealIP)
@@ -41,9 +44,10 @@ func RunServer(cfg *Config, fsm *forward.ForwardSessionManager) error {
 	}
 
 	rsvr := &RestServer{
-		logger: fsm.Logger(),
-		config: cfg,
-		fsm:    fsm,
+		logger:  fsm.Logger(),
+		config:  cfg,
+		fsm:     fsm,
+		capture: ci,
 	}
 
 	setupStatic(r, cfg.RestPrefix)
@@ -52,6 +56,8 @@ func RunServer(cfg *Config, fsm *forward.ForwardSessionManager) error {
 	api.Get("/streams", rsvr.listStreamsHandler)
 	api.Get("/streams/sse", rsvr.listStreamsSseHandler)
 	api.Post("/forward", rsvr.createForwardSessionHandler)
+	api.Get("/capture/filters", rsvr.socketFiltersHandler)
+	api.Get("/rejected", rsvr.rejectedHandler)
 	r.Handle("/metrics", promhttp.Handler())
 	r.HandleFunc("/debug/pprof/", pprof.Index)
 	r.HandleFunc("/debug/pprof/allocs", pprof.Handler("allocs").ServeHTTP)
@@ -94,8 +100,10 @@ func RunServer(cfg *Config, fsm *forward.ForwardSessionManager) error {
 // forwardReq represents the JSON request payload for starting packet forwarding
 type forwardReq struct {
 	SrcIP        string         `json:"src_ip"`
-	ErspanID     uint16         `json:"erspan_id"`
+	ErspanID     uint32         `json:"erspan_id"`
+	Encap        string         `json:"encap"`
 	StreamInfoID string         `json:"stream_info_id"`
+	Streams      []string       `json:"streams"`
 	Type         string         `json:"type"`
 	Filter       string         `json:"filter"`
 	Config       map[string]any `json:"cfg"`
@@ -107,14 +115,46 @@ func (rsvr *RestServer) createForwardSessionHandler(w http.ResponseWriter, r *ht
 		http.Error(w, "invalid JSON body", http.StatusBadRequest)
 		return
 	}
-	rsvr.logger.Info("Received forward request", "src_ip", req.SrcIP, "erspan_id", req.ErspanID, "stream_info_id", req.StreamInfoID, "type", req.Type, "filter", req.Filter, "cfg", req.Config)
-	si, err := rsvr.fsm.CreateForwardSessionByKey(
-		internal.StreamKey{
-			SrcIP:    internal.IPv4FromString(req.SrcIP),
-			ErspanID: req.ErspanID,
-		},
-		req.Type, req.Filter, req.Config,
-	)
+	rsvr.logger.Info("Received forward request", "src_ip", req.SrcIP, "erspan_id", req.ErspanID, "stream_info_id", req.StreamInfoID, "streams", req.Streams, "type", req.Type, "filter", req.Filter, "cfg", req.Config)
+	hasKey := req.SrcIP != "" || req.ErspanID != 0 || req.Encap != ""
+	forms := 0
+	for _, given := range []bool{req.StreamInfoID != "", hasKey, len(req.Streams) > 0} {
+		if given {
+			forms++
+		}
+	}
+	if forms != 1 {
+		http.Error(w, "give exactly one of stream_info_id, src_ip with erspan_id and encap, or streams", http.StatusBadRequest)
+		return
+	}
+	var si forward.ForwardSessionChannel
+	var err error
+	if len(req.Streams) > 0 {
+		si, err = rsvr.fsm.CreateForwardSessionByStreams(req.Streams, req.Type, req.Filter, req.Config)
+	} else if req.StreamInfoID != "" {
+		si, err = rsvr.fsm.CreateForwardSessionByStreamInfoID(req.StreamInfoID, req.Type, req.Filter, req.Config)
+	} else {
+		var srcIP netip.Addr
+		srcIP, err = netip.ParseAddr(req.SrcIP)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid src_ip: %v", err), http.StatusBadRequest)
+			return
+		}
+		var encap internal.Encap
+		encap, err = internal.ParseEncap(req.Encap)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		si, err = rsvr.fsm.CreateForwardSessionByKey(
+			internal.StreamKey{
+				SrcIP:    srcIP.Unmap(),
+				ErspanID: req.ErspanID,
+				Encap:    encap,
+			},
+			req.Type, req.Filter, req.Config,
+		)
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to create forward session: %v", err), http.StatusBadRequest)
 		return
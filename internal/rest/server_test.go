@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"anthonyuk.dev/erspan-hub/internal/forward"
+)
+
+func TestCreateForwardSessionForms(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	rsvr := &RestServer{logger: logger, fsm: forward.NewForwardSessionManager(&forward.Config{}, logger)}
+	tests := []struct {
+		body string
+		form bool // exactly one form is given, the request fails later on the unknown type
+	}{
+		{`{"type": "none"}`, false},
+		{`{"type": "none", "stream_info_id": "10.1.2.3/42", "src_ip": "10.1.2.3"}`, false},
+		{`{"type": "none", "stream_info_id": "10.1.2.3/42", "streams": ["10.1.2.3/*"]}`, false},
+		{`{"type": "none", "src_ip": "10.1.2.3", "erspan_id": 42, "streams": ["*"]}`, false},
+		{`{"type": "none", "stream_info_id": "10.1.2.3/42", "erspan_id": 42}`, false},
+		{`{"type": "none", "streams": ["*"], "encap": "vxlan"}`, false},
+		{`{"type": "none", "src_ip": "10.1.2.3", "erspan_id": 42, "encap": "vxlan"}`, true},
+		{`{"type": "none", "streams": ["*"]}`, true},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		rsvr.createForwardSessionHandler(w, httptest.NewRequest(http.MethodPost, "/forward", strings.NewReader(tt.body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", tt.body, w.Code, http.StatusBadRequest)
+		}
+		if got := strings.Contains(w.Body.String(), forward.ErrUnknownType.Error()); got != tt.form {
+			t.Errorf("%s: %q", tt.body, w.Body.String())
+		}
+	}
+}
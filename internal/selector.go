@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StreamSelector picks the streams of a forward session: a single stream key, all
+// sessions of an exporter ("10.1.2.3/*") or all captured streams ("*")
+type StreamSelector struct {
+	Key   StreamKey // SrcIP and Encap only if AnyID is set, unused if All is set
+	AnyID bool      // any session ID of the exporter
+	All   bool      // any stream but the virtual ones, which merge other streams
+}
+
+// KeySelector returns the selector for a single stream
+func KeySelector(key StreamKey) StreamSelector {
+	return StreamSelector{Key: key}
+}
+
+// ParseStreamSelector parses a stream key, "[encap:]addr/*" or "*"
+func ParseStreamSelector(s string) (StreamSelector, error) {
+	if s == "*" {
+		return StreamSelector{All: true}, nil
+	}
+	if exporter, ok := strings.CutSuffix(s, "/*"); ok {
+		key, err := ParseStreamKey(exporter + "/0")
+		if err != nil {
+			return StreamSelector{}, fmt.Errorf("invalid stream selector %q: %w", s, err)
+		}
+		return StreamSelector{Key: key, AnyID: true}, nil
+	}
+	key, err := ParseStreamKey(s)
+	if err != nil {
+		return StreamSelector{}, err
+	}
+	return KeySelector(key), nil
+}
+
+// IsKey reports whether the selector picks a single stream
+func (sel StreamSelector) IsKey() bool {
+	return !sel.All && !sel.AnyID
+}
+
+// Matches reports whether the selector picks a stream
+func (sel StreamSelector) Matches(key StreamKey) bool {
+	switch {
+	case sel.All:
+		return key.Encap != EncapVirtual
+	case sel.AnyID:
+		return key.SrcIP == sel.Key.SrcIP && key.Encap == sel.Key.Encap
+	}
+	return key == sel.Key
+}
+
+func (sel StreamSelector) String() string {
+	switch {
+	case sel.All:
+		return "*"
+	case sel.AnyID:
+		return strings.TrimSuffix(sel.Key.String(), "/0") + "/*"
+	}
+	return sel.Key.String()
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseStreamSelector(t *testing.T) {
+	v4, v6 := netip.MustParseAddr("10.1.2.3"), netip.MustParseAddr("2001:db8::1")
+	tests := []struct {
+		in   string
+		want StreamSelector
+		str  string // String of the parsed selector, empty if it is in
+	}{
+		{"*", StreamSelector{All: true}, ""},
+		{"10.1.2.3/42", KeySelector(StreamKey{SrcIP: v4, ErspanID: 42}), ""},
+		{"2001:db8::1/5", KeySelector(StreamKey{SrcIP: v6, ErspanID: 5}), ""},
+		{"vxlan:2001:db8::1/5", KeySelector(StreamKey{SrcIP: v6, ErspanID: 5, Encap: EncapVXLAN}), ""},
+		{"::ffff:10.0.0.1/1", KeySelector(StreamKey{SrcIP: netip.MustParseAddr("10.0.0.1"), ErspanID: 1}), "10.0.0.1/1"},
+		{"10.1.2.3/*", StreamSelector{Key: StreamKey{SrcIP: v4}, AnyID: true}, ""},
+		{"2001:db8::1/*", StreamSelector{Key: StreamKey{SrcIP: v6}, AnyID: true}, ""},
+		{"tzsp:10.1.2.3/*", StreamSelector{Key: StreamKey{SrcIP: v4, Encap: EncapTZSP}, AnyID: true}, ""},
+	}
+	for _, tt := range tests {
+		got, err := ParseStreamSelector(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("%q: %+v, %v, want %+v", tt.in, got, err, tt.want)
+			continue
+		}
+		str := tt.str
+		if str == "" {
+			str = tt.in
+		}
+		if got.String() != str {
+			t.Errorf("%q: formats as %q, want %q", tt.in, got.String(), str)
+		}
+	}
+}
+
+func TestParseStreamSelectorInvalid(t *testing.T) {
+	for _, in := range []string{"", "**", "*/*", "/*", "10.1.2.3", "10.1.2.3/", "10.1.2.3/x", "foo:10.1.2.3/*", "10.1.2.3/*/*"} {
+		if sel, err := ParseStreamSelector(in); err == nil {
+			t.Errorf("%q: parsed as %+v", in, sel)
+		}
+	}
+}
+
+func TestStreamSelectorMatches(t *testing.T) {
+	v4 := netip.MustParseAddr("10.1.2.3")
+	key := StreamKey{SrcIP: v4, ErspanID: 42}
+	otherID := StreamKey{SrcIP: v4, ErspanID: 43}
+	otherEncap := StreamKey{SrcIP: v4, ErspanID: 42, Encap: EncapVXLAN}
+	otherAddr := StreamKey{SrcIP: netip.MustParseAddr("10.1.2.4"), ErspanID: 42}
+	virtual := VirtualStreamKey("merged")
+
+	tests := []struct {
+		sel  string
+		key  StreamKey
+		want bool
+	}{
+		{"10.1.2.3/42", key, true},
+		{"10.1.2.3/42", otherID, false},
+		{"10.1.2.3/42", otherEncap, false},
+		{"10.1.2.3/*", key, true},
+		{"10.1.2.3/*", otherID, true},
+		{"10.1.2.3/*", otherEncap, false},
+		{"10.1.2.3/*", otherAddr, false},
+		{"vxlan:10.1.2.3/*", otherEncap, true},
+		{"*", key, true},
+		{"*", otherEncap, true},
+		{"*", virtual, false},
+		{virtual.String(), virtual, true},
+	}
+	for _, tt := range tests {
+		sel, err := ParseStreamSelector(tt.sel)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.sel, err)
+		}
+		if got := sel.Matches(tt.key); got != tt.want {
+			t.Errorf("%q matches %v: %v, want %v", tt.sel, tt.key, got, tt.want)
+		}
+	}
+}
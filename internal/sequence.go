@@ -0,0 +1,64 @@
+package internal
+
+// seqResetThreshold is the sequence number jump that is treated as the exporter
+// restarting its GRE sequence rather than as packet loss
+const seqResetThreshold = 1 << 16
+
+// SequenceStats tracks the GRE sequence numbers of a stream and counts lost,
+// duplicated and out-of-order packets.
+// A packet that arrives after a later one is first counted as lost, then moved
+// to out-of-order when it arrives.
+type SequenceStats struct {
+	SeqLost       uint64 `json:"seq_lost"`
+	SeqDuplicate  uint64 `json:"seq_duplicate"`
+	SeqOutOfOrder uint64 `json:"seq_out_of_order"`
+	started       bool
+	highest       uint32
+	// bit i is set if sequence number highest-1-i has been seen
+	window uint64
+}
+
+// TrackSequence updates the statistics with the sequence number of a received packet
+func (ss *SequenceStats) TrackSequence(seq uint32) {
+	if !ss.started {
+		ss.started = true
+		ss.highest = seq
+		ss.window = 0
+		return
+	}
+	d := int64(int32(seq - ss.highest))
+	switch {
+	case d == 0:
+		ss.SeqDuplicate++
+	case d >= seqResetThreshold || d <= -seqResetThreshold:
+		ss.highest = seq
+		ss.window = 0
+	case d > 0:
+		ss.SeqLost += uint64(d - 1)
+		if d > 64 {
+			ss.window = 0
+		} else {
+			ss.window = ss.window<<d | 1<<(d-1)
+		}
+		ss.highest = seq
+	default:
+		bit := -d - 1
+		if bit >= 64 {
+			// too old to tell whether it is a duplicate
+			ss.SeqOutOfOrder++
+			if ss.SeqLost > 0 {
+				ss.SeqLost--
+			}
+			return
+		}
+		if ss.window&(1<<bit) != 0 {
+			ss.SeqDuplicate++
+			return
+		}
+		ss.window |= 1 << bit
+		ss.SeqOutOfOrder++
+		if ss.SeqLost > 0 {
+			ss.SeqLost--
+		}
+	}
+}
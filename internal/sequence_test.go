@@ -0,0 +1,40 @@
+package internal
+
+import "testing"
+
+func TestTrackSequence(t *testing.T) {
+	tests := []struct {
+		name       string
+		seqs       []uint32
+		lost       uint64
+		duplicate  uint64
+		outOfOrder uint64
+	}{
+		{"in order", []uint32{1, 2, 3, 4, 5}, 0, 0, 0},
+		{"gap", []uint32{1, 2, 5, 6}, 2, 0, 0},
+		{"reordered", []uint32{1, 3, 2, 4}, 0, 0, 1},
+		{"reordered pair", []uint32{1, 4, 3, 2, 5}, 0, 0, 2},
+		{"reordered at window edge", []uint32{1, 66, 2}, 63, 0, 1},
+		{"late beyond window", []uint32{1, 101, 20}, 98, 0, 1},
+		{"duplicate", []uint32{1, 2, 2, 3}, 0, 1, 0},
+		{"duplicate in window", []uint32{1, 2, 3, 2, 1}, 0, 2, 0},
+		{"duplicate of reordered", []uint32{1, 3, 2, 2}, 0, 1, 1},
+		{"first duplicate", []uint32{7, 7}, 0, 1, 0},
+		{"wraparound", []uint32{0xfffffffe, 0xffffffff, 0, 1}, 0, 0, 0},
+		{"gap over wraparound", []uint32{0xfffffffe, 1}, 2, 0, 0},
+		{"reordered over wraparound", []uint32{0xffffffff, 1, 0}, 0, 0, 1},
+		{"duplicate over wraparound", []uint32{0xffffffff, 0, 0xffffffff}, 0, 1, 0},
+		{"reset", []uint32{100000, 1, 2, 3}, 0, 0, 0},
+		{"jump", []uint32{1, 1 << 20, 1<<20 + 1}, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		var ss SequenceStats
+		for _, seq := range tt.seqs {
+			ss.TrackSequence(seq)
+		}
+		if ss.SeqLost != tt.lost || ss.SeqDuplicate != tt.duplicate || ss.SeqOutOfOrder != tt.outOfOrder {
+			t.Errorf("%s: lost %d, duplicate %d, out of order %d, want %d, %d, %d",
+				tt.name, ss.SeqLost, ss.SeqDuplicate, ss.SeqOutOfOrder, tt.lost, tt.duplicate, tt.outOfOrder)
+		}
+	}
+}
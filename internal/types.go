@@ -1,71 +1,272 @@
 package internal
 
 import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"net"
+	"net/netip"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// IPv4 represents a 4-byte IPv4 address that can be used as a map key
-type IPv4 [4]byte
-
-func (ip IPv4) ToNetIP() net.IP {
-	return net.IP(ip[:])
+// AddrToUint32 returns an IPv4 address as a uint32, or 0 for an IPv6 address
+func AddrToUint32(ip netip.Addr) uint32 {
+	if !ip.Is4() {
+		return 0
+	}
+	b := ip.As4()
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
 }
 
-func (ip IPv4) String() string {
-	return net.IP(ip[:]).String()
+// ParsePrefixes parses exporter prefixes, a plain address is a single host
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return nil, fmt.Errorf("invalid prefix %q: %w", s, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
 }
 
-func (ip IPv4) ToUint32() uint32 {
-	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
-}
+// Encap identifies how a stream is mirrored to the hub
+type Encap uint8
+
+const (
+	EncapERSPAN Encap = iota
+	EncapVXLAN
+	EncapTZSP
+	EncapTEB
+	EncapVirtual // merged from other streams by the hub, see VirtualStreamKey
+)
 
-func (ip IPv4) MarshalJSON() ([]byte, error) {
-	return json.Marshal(ip.String())
+var encapNames = map[Encap]string{
+	EncapERSPAN:  "erspan",
+	EncapVXLAN:   "vxlan",
+	EncapTZSP:    "tzsp",
+	EncapTEB:     "teb",
+	EncapVirtual: "virtual",
 }
 
-func IPFromUint32(u uint32) IPv4 {
-	return IPv4{
-		byte((u >> 24) & 0xFF),
-		byte((u >> 16) & 0xFF),
-		byte((u >> 8) & 0xFF),
-		byte(u & 0xFF),
+func (e Encap) String() string {
+	if name, ok := encapNames[e]; ok {
+		return name
 	}
+	return fmt.Sprintf("encap-%d", uint8(e))
 }
 
-func IPv4FromString(s string) IPv4 {
-	var ip IPv4
-	parsed := net.ParseIP(s).To4()
-	if parsed != nil {
-		copy(ip[:], parsed)
+func (e Encap) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// ParseEncap converts an encapsulation name to an Encap, an empty name is ERSPAN
+func ParseEncap(s string) (Encap, error) {
+	if s == "" {
+		return EncapERSPAN, nil
+	}
+	for e, name := range encapNames {
+		if name == s {
+			return e, nil
+		}
 	}
-	return ip
+	return EncapERSPAN, fmt.Errorf("unknown encapsulation: %s", s)
 }
 
-// StreamKey uniquely identifies an ERSPAN stream by source IP and ERSPAN session ID
+// StreamKey uniquely identifies a stream by source IP (IPv4 or IPv6), session ID and encapsulation.
+// For ERSPAN the session ID is the ERSPAN ID, for VXLAN it is the VNI, for TZSP the sensor ID
+// and for transparent Ethernet bridging the GRE key.
 type StreamKey struct {
-	SrcIP    IPv4   `json:"src_ip"`
-	ErspanID uint16 `json:"erspan_id"`
+	SrcIP    netip.Addr `json:"src_ip"`
+	ErspanID uint32     `json:"erspan_id"`
+	Encap    Encap      `json:"encap"`
 }
 
-var NullStreamKey = StreamKey{SrcIP: IPv4{0, 0, 0, 0}, ErspanID: 65535}
+var NullStreamKey = StreamKey{SrcIP: netip.Addr{}, ErspanID: 65535}
 
 func (sk StreamKey) String() string {
-	return fmt.Sprintf("%s/%d", sk.SrcIP.String(), sk.ErspanID)
+	if sk.Encap == EncapERSPAN {
+		return fmt.Sprintf("%s/%d", sk.SrcIP.String(), sk.ErspanID)
+	}
+	return fmt.Sprintf("%s:%s/%d", sk.Encap, sk.SrcIP.String(), sk.ErspanID)
+}
+
+// encode returns the key as a fixed size byte string
+func (sk StreamKey) encode() [21]byte {
+	var b [21]byte
+	ip := sk.SrcIP.As16()
+	copy(b[:16], ip[:])
+	binary.BigEndian.PutUint32(b[16:20], sk.ErspanID)
+	b[20] = byte(sk.Encap)
+	return b
+}
+
+// Hash is an FNV-1a hash of a stream key
+func (sk StreamKey) Hash() uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	h := uint64(offset)
+	for _, c := range sk.encode() {
+		h ^= uint64(c)
+		h *= prime
+	}
+	return h
+}
+
+// ID returns the stream ID for a key. It is derived from the key alone, so a stream
+// keeps its ID across hub restarts.
+func (sk StreamKey) ID() string {
+	b := sk.encode()
+	sum := sha256.Sum256(b[:])
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:16])
+}
+
+// VirtualStreamKey returns the key of a virtual stream, with an FNV-1a hash of its name as session ID
+func VirtualStreamKey(name string) StreamKey {
+	h := uint32(2166136261)
+	for _, c := range []byte(name) {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return StreamKey{SrcIP: netip.IPv4Unspecified(), ErspanID: h, Encap: EncapVirtual}
+}
+
+// ParseStreamKey parses a stream key in the form returned by StreamKey.String
+func ParseStreamKey(s string) (StreamKey, error) {
+	var sk StreamKey
+	rest, id, ok := strings.Cut(s, "/")
+	if !ok {
+		return sk, fmt.Errorf("invalid stream key %q: missing session ID", s)
+	}
+	if name, addr, ok := strings.Cut(rest, ":"); ok {
+		if encap, err := ParseEncap(name); err == nil && name != "" {
+			sk.Encap, rest = encap, addr
+		}
+	}
+	addr, err := netip.ParseAddr(rest)
+	if err != nil {
+		return sk, fmt.Errorf("invalid stream key %q: %w", s, err)
+	}
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return sk, fmt.Errorf("invalid stream key %q: %w", s, err)
+	}
+	sk.SrcIP, sk.ErspanID = addr.Unmap(), uint32(n)
+	return sk, nil
 }
 
+// ERSPAN types as reported in StreamInfo.ErspanVersion
+const (
+	ErspanTypeI   uint8 = 1
+	ErspanTypeII  uint8 = 2
+	ErspanTypeIII uint8 = 3
+)
+
+// TimestampSource identifies the clock used to timestamp captured packets
+type TimestampSource string
+
+const (
+	TimestampSourceHub      TimestampSource = "hub"      // hub clock after the packet is read from the socket
+	TimestampSourceKernel   TimestampSource = "kernel"   // kernel receive timestamp
+	TimestampSourceHardware TimestampSource = "hardware" // NIC receive timestamp, taken as UTC, wrong if the NIC clock runs TAI or unsynchronised
+	TimestampSourceErspan   TimestampSource = "erspan"   // ERSPAN Type III hardware timestamp
+)
+
+// PacketInfo carries the metadata of a captured packet alongside the mirrored frame
+type PacketInfo struct {
+	Key             StreamKey
+	ErspanVersion   uint8
+	Timestamp       time.Time
+	TimestampSource TimestampSource
+	HasSeq          bool // GRE sequence number present
+	Seq             uint32
+}
+
+// StreamState is the lifecycle state of a stream, derived from when its last packet arrived
+type StreamState string
+
+const (
+	StreamStateActive  StreamState = "active"  // packets seen within the idle timeout
+	StreamStateIdle    StreamState = "idle"    // no packets for the idle timeout
+	StreamStateExpired StreamState = "expired" // no packets for the expiry timeout, about to be removed
+)
+
+// StreamInfo is a point-in-time view of a stream in the registry
 type StreamInfo struct {
 	ID              string            `json:"id"`
-	SrcIP           IPv4              `json:"src_ip"`
-	ErspanID        uint16            `json:"erspan_id"`
+	SrcIP           netip.Addr        `json:"src_ip"`
+	ErspanID        uint32            `json:"erspan_id"`
+	Encap           Encap             `json:"encap"`
 	ErspanVersion   uint8             `json:"erspan_version"`
 	FirstSeen       time.Time         `json:"first_seen"`
 	LastSeen        time.Time         `json:"last_seen"`
 	Packets         uint64            `json:"packets"`
 	Bytes           uint64            `json:"bytes"`
+	TimestampSource TimestampSource   `json:"timestamp_source"`
+	State           StreamState       `json:"state"`
 	ForwardSessions ForwardSessionSet `json:"forward_sessions"`
+	StreamMeta
+	SequenceStats
+}
+
+// StreamMeta describes a stream for humans, taken from the inventory
+type StreamMeta struct {
+	Name        string            `json:"name,omitempty"`
+	Site        string            `json:"site,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// Matches reports whether a stream matches a search query. Each word of the query must
+// match, case-insensitively: "key=value" matches a label, anything else a substring of
+// the stream key, ID, name, site, description or a label.
+func (si *StreamInfo) Matches(query string) bool {
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		if !si.matchesTerm(term) {
+			return false
+		}
+	}
+	return true
+}
+
+func (si *StreamInfo) matchesTerm(term string) bool {
+	if k, v, ok := strings.Cut(term, "="); ok {
+		for lk, lv := range si.Labels {
+			if strings.ToLower(lk) == k && strings.ToLower(lv) == v {
+				return true
+			}
+		}
+		return false
+	}
+	for _, f := range []string{si.Key().String(), si.ID, si.Name, si.Site, si.Description} {
+		if strings.Contains(strings.ToLower(f), term) {
+			return true
+		}
+	}
+	for lk, lv := range si.Labels {
+		if strings.Contains(strings.ToLower(lk), term) || strings.Contains(strings.ToLower(lv), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns the key of the stream
+func (si *StreamInfo) Key() StreamKey {
+	return StreamKey{SrcIP: si.SrcIP, ErspanID: si.ErspanID, Encap: si.Encap}
 }
 
 // ForwardSession represents a session forwarding packets from a specific ERSPAN stream
@@ -91,10 +292,13 @@ const (
 	ForwardSessionMsgTypeShutdown
 )
 
+// ForwardSessionMsg is sent to a forward session. The receiver of a packet
+// message holds a reference to Buffer and must release it after use.
 type ForwardSessionMsg struct {
 	Type   ForwardSessionMsgType
-	Packet []byte
+	Buffer *PacketBuffer
 	Time   time.Time
+	Key    StreamKey // stream of a packet
 }
 
 // MarshalJSON implements custom JSON marshalling for ForwardSessionSet
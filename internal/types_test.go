@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseStreamKey(t *testing.T) {
+	tests := []struct {
+		in   string
+		want StreamKey
+		str  string // String of the parsed key, empty if it is in
+	}{
+		{"10.1.2.3/42", StreamKey{SrcIP: netip.MustParseAddr("10.1.2.3"), ErspanID: 42}, ""},
+		{"2001:db8::1/5", StreamKey{SrcIP: netip.MustParseAddr("2001:db8::1"), ErspanID: 5}, ""},
+		{"vxlan:2001:db8::1/5", StreamKey{SrcIP: netip.MustParseAddr("2001:db8::1"), ErspanID: 5, Encap: EncapVXLAN}, ""},
+		{"tzsp:10.1.2.3/4294967295", StreamKey{SrcIP: netip.MustParseAddr("10.1.2.3"), ErspanID: 4294967295, Encap: EncapTZSP}, ""},
+		{"teb:10.1.2.3/0", StreamKey{SrcIP: netip.MustParseAddr("10.1.2.3"), Encap: EncapTEB}, ""},
+		{"erspan:10.1.2.3/1", StreamKey{SrcIP: netip.MustParseAddr("10.1.2.3"), ErspanID: 1}, "10.1.2.3/1"},
+		{"::ffff:10.0.0.1/1", StreamKey{SrcIP: netip.MustParseAddr("10.0.0.1"), ErspanID: 1}, "10.0.0.1/1"},
+		{"::1/7", StreamKey{SrcIP: netip.IPv6Loopback(), ErspanID: 7}, ""},
+	}
+	for _, tt := range tests {
+		got, err := ParseStreamKey(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("%q: %v, %v, want %v", tt.in, got, err, tt.want)
+			continue
+		}
+		str := tt.str
+		if str == "" {
+			str = tt.in
+		}
+		if got.String() != str {
+			t.Errorf("%q: formats as %q, want %q", tt.in, got.String(), str)
+		}
+	}
+}
+
+func TestParseStreamKeyInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"10.1.2.3",
+		"10.1.2.3/",
+		"/1",
+		"10.1.2.3/x",
+		"10.1.2.3/-1",
+		"10.1.2.3/4294967296",
+		"10.1.2/1",
+		"foo:10.1.2.3/1",
+		"vxlan:/1",
+		"2001:db8::1",
+		"*",
+		"10.1.2.3/*",
+	} {
+		if key, err := ParseStreamKey(in); err == nil {
+			t.Errorf("%q: parsed as %v", in, key)
+		}
+	}
+}
+
+func TestStreamInfoMatches(t *testing.T) {
+	si := &StreamInfo{
+		ID:       StreamKey{SrcIP: netip.MustParseAddr("10.1.2.3"), ErspanID: 42}.ID(),
+		SrcIP:    netip.MustParseAddr("10.1.2.3"),
+		ErspanID: 42,
+		StreamMeta: StreamMeta{
+			Name:        "core-sw1 uplink",
+			Site:        "London",
+			Description: "Mirror of the WAN uplink",
+			Labels:      map[string]string{"Role": "WAN", "vlan": "100"},
+		},
+	}
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"core-sw1", true},
+		{"LONDON", true},
+		{"10.1.2.3/42", true},
+		{si.ID[:8], true},
+		{"wan uplink", true},
+		{"role=wan", true},
+		{"Role=WAN", true},
+		{"vlan=100", true},
+		{"vlan=10", false},
+		{"role=lan", false},
+		{"site=london", false}, // only labels match key=value
+		{"london role=wan", true},
+		{"london role=lan", false},
+		{"paris role=wan", false},
+		{"role", true},
+		{"10.1.2.4", false},
+	}
+	for _, tt := range tests {
+		if got := si.Matches(tt.query); got != tt.want {
+			t.Errorf("%q: %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}